@@ -25,10 +25,14 @@ package chu
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 var _ http.Handler = &Mux{}
@@ -38,153 +42,367 @@ type Middleware func(http.Handler) http.Handler
 
 // Mux 路由
 type Mux struct {
-	// 所有节点
-	nodes []*node // MaxInt16
+	// 路由树，Route、Group 创建的子路由和父路由共用同一棵树
+	*tree
 
-	// 邻接表，next[i] = [j1, j2, ... jn] 表示节点 i 可以到达 j1, j2, ... jn
-	next [][]int
+	// 父路由，由 New 创建的根路由为 nil
+	parent *Mux
+
+	// 路由前缀，注册路由时会加在 path 前面
+	prefix string
+
+	// 中间件
+	// 根路由的中间件在路由匹配之前执行，对所有请求生效（包括 404 和 405），
+	// 子路由的中间件只对通过子路由注册的路由生效，在路由匹配之后执行
+	middlewares []Middleware
+
+	// 根路由的中间件和路由匹配组成的 http.Handler，每次 Use 时重新生成
+	handler http.Handler
+
+	// Host 创建的子路由及其子路由所属的 Host 子路由，为 nil 时使用 tree 中没有 host 限制的路由表
+	host *hostRouter
+
+	// When 创建的子路由及其子路由注册路由时使用的匹配条件
+	matchers []Matcher
+}
+
+// tree 路由树
+type tree struct {
+	// 当前的路由表（*table），处理请求时原子地读取
+	current atomic.Value
+
+	// 修改路由表时加锁，保证同时只有一个修改
+	mu sync.Mutex
+
+	// Host 创建的子路由（[]*hostRouter），处理请求时原子地读取
+	hosts atomic.Value
+
+	// 开启后修改路由表时复制一份新的 table，不修改正在被读取的 table，见 WithHotSwap
+	hotSwap bool
 
 	// Context 池，当从 URL 中获取到参数时，从这里面拿 Context 来存放参数
 	// 避免多次分配内存
 	contextPool *sync.Pool
 
-	// 中间件
-	middlewares []Middleware
+	// 找不到路由和 HTTP Method 不匹配时使用的 handler，为 nil 时使用默认的
+	notFound         http.Handler
+	methodNotAllowed http.Handler
+
+	// 自动回应 OPTIONS 请求，optionsHandler 为 nil 时返回 204
+	autoOptions    bool
+	optionsHandler http.Handler
+
+	// HEAD 请求没有注册时使用 GET 的 handler 处理
+	autoHead bool
+
+	// 请求路径末尾的 '/' 和路由不一致时重定向使用的状态码，为 0 时不重定向
+	redirectSlash int
+	// 请求路径不规范时重定向到 path.Clean 之后的路径使用的状态码，为 0 时不处理
+	cleanPath int
+
+	// 使用编码后的路径匹配路由，参数值单独解码
+	rawPath bool
+
+	// 请求路径的大小写和注册的路由不一致时重定向使用的状态码，为 0 时不重定向
+	caseRedirect int
 }
 
-// New return a *Mux
-func New() *Mux {
-	cnt := 500
-	nodes := make([]*node, 0, cnt)
-	next := make([][]int, cnt)
-	for i := range next {
-		next[i] = make([]int, 0, cnt/50)
+// load 返回当前的路由表
+func (t *tree) load() *table {
+	return t.current.Load().(*table)
+}
+
+// update 修改路由表，开启 hotSwap 时在新复制的 table 上修改，完成后再替换掉旧的 table
+// fn panic 时，新的 table 不会生效
+func (t *tree) update(fn func(tb *table)) {
+	t.updateValue(&t.current, fn)
+}
+
+// updateValue 和 update 相同，修改的是 current 中的路由表
+func (t *tree) updateValue(current *atomic.Value, fn func(tb *table)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tb := current.Load().(*table)
+	if t.hotSwap {
+		tb = tb.clone()
 	}
+	fn(tb)
+	current.Store(tb)
+}
+
+// load 返回 m 使用的路由表，Host 子路由使用自己的路由表
+func (m *Mux) load() *table {
+	if m.host != nil {
+		return m.host.load()
+	}
+	return m.tree.load()
+}
+
+// update 修改 m 使用的路由表
+func (m *Mux) update(fn func(tb *table)) {
+	if m.host != nil {
+		m.tree.updateValue(&m.host.current, fn)
+		return
+	}
+	m.tree.update(fn)
+}
+
+// defaultCapacity 默认为路由树预先分配的节点数量
+const defaultCapacity = 64
+
+// New return a *Mux
+func New(opts ...Option) *Mux {
 	contextPool := sync.Pool{
 		New: func() interface{} {
 			return NewChuContext()
 		},
 	}
-	return &Mux{
-		nodes:       nodes,
-		next:        next,
-		contextPool: &contextPool,
+	m := &Mux{
+		tree: &tree{
+			contextPool: &contextPool,
+		},
+	}
+	m.current.Store(&table{
+		nodes:   make([]*node, 0, defaultCapacity),
+		pool:    &contextPool,
+		methods: defaultMethods,
+	})
+	m.handler = http.HandlerFunc(m.routeHTTP)
+	for _, opt := range opts {
+		opt(m)
 	}
+	return m
 }
 
-// Show 打印所有可用路由
+// Show 打印路由树的所有节点和可用路由，用于调试
+//
+// Deprecated: 使用 Routes 或 Walk 获取路由信息
 func (m *Mux) Show() {
-	fmt.Printf("len(m.nodes): %v\n", len(m.nodes))
-	for i, n := range m.nodes {
-		fmt.Printf("idx(%v): ", i)
-		indent := strings.Repeat("  ", n.level)
-		fmt.Printf("%v%#v allowMethods: %d, ", indent, n.seg, n.allowMethods)
-		fmt.Printf("n.wildcard: %v, ", n.wildcard)
-		fmt.Printf("n.wildchild: %v\n", n.wildchild)
+	tb := m.load()
+	fmt.Printf("len(m.nodes): %v\n", len(tb.nodes))
+	if len(tb.nodes) > 0 {
+		tb.walk(0, func(idx int) error {
+			depth := 0
+			for i := idx; i != 0; i = tb.nodes[i].parent {
+				depth++
+			}
+			n := tb.nodes[idx]
+			fmt.Printf("idx(%v): ", idx)
+			fmt.Printf("%v%#v allowMethods: %d, ", strings.Repeat("  ", depth), n.prefix, n.allowMethods)
+			fmt.Printf("n.kind: %v\n", n.kind)
+			return nil
+		})
 	}
 
-	if len(m.nodes) > 0 {
-		dfs(0, m.nodes, m.next, []string{""})
+	for _, ri := range m.Routes() {
+		fmt.Printf("%s %#v %s\n", ri.Method, ri.Pattern, ri.Handler)
 	}
 }
 
-func (m *Mux) handle(method, path string, handler http.Handler) {
-	if len(m.nodes) == 0 {
-		m.nodes = append(m.nodes, &node{seg: "", level: 0})
+// handle 为 methods 中的每个 Method 注册一个路由，返回的路由和 methods 一一对应
+// 先检查所有 Method 都可以注册，再修改路由树，出错时路由树不会有任何改动
+func (m *Mux) handle(methods []string, path string, handler http.Handler) ([]*Route, error) {
+	routes := make([]*Route, len(methods))
+	for i, method := range methods {
+		routes[i] = &Route{
+			mux:      m,
+			method:   method,
+			pattern:  m.prefix + path,
+			handler:  handler,
+			matchers: m.matchers,
+		}
 	}
-	for i := len(m.middlewares) - 1; i >= 0; i-- {
-		handler = m.middlewares[i](handler)
+	var err error
+	m.update(func(tb *table) {
+		for i, rt := range routes {
+			if _, _, err = tb.prepareRoute(rt.method, rt.pattern, len(rt.matchers) != 0); err != nil {
+				return
+			}
+			for _, prev := range routes[:i] {
+				if prev.method == rt.method && len(rt.matchers) == 0 {
+					err = &RouteError{Err: ErrDuplicateRoute, Method: rt.method, Pattern: rt.pattern, Existing: rt.pattern}
+					return
+				}
+			}
+		}
+		for _, rt := range routes {
+			var idxs []int
+			if idxs, err = tb.addRoute(rt.method, rt.pattern, rt); err != nil {
+				return
+			}
+			rt.idx = idxs[len(idxs)-1]
+			if len(idxs) > 1 {
+				rt.variants = idxs
+			}
+			rt.fullPattern = tb.routePattern(rt)
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
-	addMethodToNode(method, path, handler, &m.nodes, &m.next)
+	return routes, nil
 }
 
-// Handle 注册路由
-func (m *Mux) Handle(method, path string, handler http.Handler) {
-	m.handle(method, path, handler)
+// chain 用子路由及其所有上级子路由的中间件把 handler 包起来，不包括根路由的中间件
+func (m *Mux) chain(handler http.Handler) http.Handler {
+	for r := m; r.parent != nil; r = r.parent {
+		for i := len(r.middlewares) - 1; i >= 0; i-- {
+			handler = r.middlewares[i](handler)
+		}
+	}
+	return handler
+}
+
+// root 返回 m 所在的根路由
+func (m *Mux) root() *Mux {
+	for m.parent != nil {
+		m = m.parent
+	}
+	return m
+}
+
+// Handle 注册路由，返回的 *Route 可以用来给路由命名
+// 路径不合法、路由冲突或重复注册时 panic，不希望 panic 时使用 TryHandle
+func (m *Mux) Handle(method, path string, handler http.Handler) *Route {
+	routes, err := m.handle([]string{method}, path, handler)
+	if err != nil {
+		panic(err)
+	}
+	return routes[0]
 }
 
 // HandleFunc 注册具体 func
-func (m *Mux) HandleFunc(method, path string, handle http.HandlerFunc) {
-	m.handle(method, path, handle)
+func (m *Mux) HandleFunc(method, path string, handle http.HandlerFunc) *Route {
+	return m.Handle(method, path, handle)
+}
+
+// TryHandle 和 Handle 一样注册路由，但出错时返回 *RouteError 而不是 panic，
+// 适合从配置文件等外部来源加载路由。出错时路由树不会有任何改动
+func (m *Mux) TryHandle(method, path string, handler http.Handler) error {
+	_, err := m.handle([]string{method}, path, handler)
+	return err
 }
 
-// Use 为 Mux 添加中间件
+// Remove 删除路由，path 需要和注册时的写法相同，子路由会自动加上前缀
+// 带可选段的路由也可以用展开后的任意一个路径删除，例如用 "/reports/:year" 删除
+// "/reports/:year/:month?"，这时所有展开后的路由都会被删除
+// 路由不存在时返回 error。开启 WithHotSwap 后可以在处理请求的同时删除路由
+func (m *Mux) Remove(method, path string) error {
+	var err error
+	m.update(func(tb *table) {
+		var routes []*Route
+		if routes, err = tb.removeRoute(method, m.prefix+path); err != nil {
+			return
+		}
+		for _, rt := range routes {
+			if name := rt.attributes().name; name != "" && tb.names[name] == rt {
+				delete(tb.names, name)
+			}
+		}
+	})
+	return err
+}
+
+// Use 为 Mux 添加中间件，按照添加的顺序执行
+// 根路由的中间件在路由匹配之前执行，所有请求都会经过，包括 404 和 405，
+// 子路由（Route、Group、With）的中间件只在匹配到子路由注册的路由时执行
+// 两种中间件都和注册路由的先后顺序无关，但需要在开始处理请求之前添加
 func (m *Mux) Use(middlewares ...Middleware) {
 	if m.middlewares == nil {
 		m.middlewares = make([]Middleware, 0, len(middlewares))
 	}
 	m.middlewares = append(m.middlewares, middlewares...)
+	if m.parent != nil {
+		return
+	}
+	m.handler = http.HandlerFunc(m.routeHTTP)
+	for i := len(m.middlewares) - 1; i >= 0; i-- {
+		m.handler = m.middlewares[i](m.handler)
+	}
 }
 
 // Get HandleFunc
-func (m *Mux) Get(path string, handle http.HandlerFunc) {
-	m.HandleFunc(http.MethodGet, path, handle)
+func (m *Mux) Get(path string, handle http.HandlerFunc) *Route {
+	return m.HandleFunc(http.MethodGet, path, handle)
 }
 
 // Post HandleFunc
-func (m *Mux) Post(path string, handle http.HandlerFunc) {
-	m.HandleFunc(http.MethodPost, path, handle)
+func (m *Mux) Post(path string, handle http.HandlerFunc) *Route {
+	return m.HandleFunc(http.MethodPost, path, handle)
 }
 
 // Delete HandleFunc
-func (m *Mux) Delete(path string, handle http.HandlerFunc) {
-	m.HandleFunc(http.MethodDelete, path, handle)
+func (m *Mux) Delete(path string, handle http.HandlerFunc) *Route {
+	return m.HandleFunc(http.MethodDelete, path, handle)
 }
 
 // Put HandleFunc
-func (m *Mux) Put(path string, handle http.HandlerFunc) {
-	m.HandleFunc(http.MethodPut, path, handle)
+func (m *Mux) Put(path string, handle http.HandlerFunc) *Route {
+	return m.HandleFunc(http.MethodPut, path, handle)
 }
 
 // Head HandleFunc
-func (m *Mux) Head(path string, handle http.HandlerFunc) {
-	m.HandleFunc(http.MethodHead, path, handle)
-}
-
-// findMatchedNode 返回根据 http method 和 URL path 匹配到的节点的编号和 Context
-// Context 中有从 URL path 中获取的参数，如果匹配失败，返回编号为 -1。
-// 与 getLastMatchedNodeIdx 不同，findMatchedNode 支持具体的参数和通配类型节点匹配
-func (m *Mux) findMatchedNode(method, path string) (idx int, ctx *Context) {
-	path = strings.TrimRight(path, "/")
-	segs := strings.Split(path, "/")
-	n := len(segs)
-	if n == 0 {
-		return 0, nil
-	}
-
-	si, idx := 1, 0
-	for si < len(segs) {
-		// a, b 只是 si 和 idx 的一个备份，用来检测 si 和 idx 是否发生变化
-		a, b := si, idx
-		for _, i := range m.next[idx] {
-			curNode := m.nodes[i]
-
-			// 如果 URL 中有参数，把参数提取出来放到 Context 中
-			if curNode.wildcard {
-				if ctx == nil {
-					ctx, _ = m.contextPool.Get().(*Context)
-				}
-				ctx.URLParams.Keys = append(ctx.URLParams.Keys, curNode.seg[1:])
-				ctx.URLParams.Values = append(ctx.URLParams.Values, segs[i])
-				si, idx = si+1, i
-				break
-			}
+func (m *Mux) Head(path string, handle http.HandlerFunc) *Route {
+	return m.HandleFunc(http.MethodHead, path, handle)
+}
 
-			if m.nodes[i].seg == segs[si] {
-				si, idx = si+1, i
-				break
-			}
-		}
+// Patch HandleFunc
+func (m *Mux) Patch(path string, handle http.HandlerFunc) *Route {
+	return m.HandleFunc(http.MethodPatch, path, handle)
+}
 
-		// si, idx 没有变化表示匹配不到
-		if a == si || b == idx {
-			if ctx != nil {
-				m.contextPool.Put(ctx)
-			}
-			return -1, nil
-		}
+// Options HandleFunc，注册后 WithAutoOptions 不再处理这个路径
+func (m *Mux) Options(path string, handle http.HandlerFunc) *Route {
+	return m.HandleFunc(http.MethodOptions, path, handle)
+}
+
+// Connect HandleFunc
+func (m *Mux) Connect(path string, handle http.HandlerFunc) *Route {
+	return m.HandleFunc(http.MethodConnect, path, handle)
+}
+
+// Trace HandleFunc
+func (m *Mux) Trace(path string, handle http.HandlerFunc) *Route {
+	return m.HandleFunc(http.MethodTrace, path, handle)
+}
+
+// Any 为所有 HTTP Method 注册同一个 handler，包括 WithMethods 添加的扩展 Method，
+// 返回的路由和 Method 的顺序一致
+func (m *Mux) Any(path string, handle http.HandlerFunc) []*Route {
+	return m.Match(m.load().methods.names, path, handle)
+}
+
+// Match 为 methods 中的每个 HTTP Method 注册同一个 handler，返回的路由和 methods 一一对应，例如
+//
+//	mux.Match([]string{http.MethodGet, http.MethodPost}, "/login", login)
+//
+// 和 Handle 一样出错时 panic，但会先检查所有 Method，有一个不能注册时所有 Method 都不会注册
+func (m *Mux) Match(methods []string, path string, handle http.HandlerFunc) []*Route {
+	routes, err := m.handle(methods, path, handle)
+	if err != nil {
+		panic(err)
 	}
-	return idx, ctx
+	return routes
+}
+
+// findMatchedNode 返回根据 http method 和 URL path 匹配到的节点和 Context
+// Context 中有从 URL path 中获取的参数，如果匹配失败，返回的节点为 nil。
+// 没有开启 WithStrictSlash 时，匹配前会去掉 path 末尾的 '/'，"/a/" 和 "/a" 匹配到同一个节点
+func (m *Mux) findMatchedNode(method, path string) (n *node, ctx *Context) {
+	tb := m.load()
+	if len(tb.nodes) == 0 {
+		return nil, nil
+	}
+	for !tb.strictSlash && len(path) > 1 && path[len(path)-1] == '/' {
+		path = path[:len(path)-1]
+	}
+
+	idx, ctx := tb.matchNode(0, path, ctx)
+	if idx == -1 {
+		putContext(m.contextPool, ctx)
+		return nil, nil
+	}
+	return tb.nodes[idx], ctx
 }
 
 // errCode 内部使用的错误码
@@ -192,47 +410,382 @@ type errCode int
 
 // Mux.getHandler 返回的错误码类型
 const (
-	_          errCode = iota
-	NotFound           // 根据 url.Path 找不到对应 Handler
-	NotAllowed         // url.Path 存在 Handler，但是 http.Method 不对
+	_                    errCode = iota
+	NotFound                     // 根据 url.Path 找不到对应 Handler
+	NotAllowed                   // url.Path 存在 Handler，但是 http.Method 不对
+	NotAcceptable                // 路由存在，但是请求不满足 Accept 开头的请求头条件
+	UnsupportedMediaType         // 路由存在，但是请求的 Content-Type 不满足条件
 )
 
-// getHandler 根据路径和 HTTP Method 匹配方法，同时返回 Context 和匹配状态码
+// getHandler 根据路径和 HTTP Method 匹配方法，同时返回 Context、路径允许的方法和匹配状态码
 // 如果找不到路径，返回的 handler 为 nil，状态码为 NotFound
 // 如果找到路径，但对应的 HTTP Method 为 nil，则返回 handle 为 nil，状态码为 NotAllowed
-func (m *Mux) getHandler(method, path string) (http.Handler, *Context, errCode) {
-	idx, ps := m.findMatchedNode(method, path)
-	if idx == -1 {
-		return nil, ps, NotFound
+// 如果路由都不满足匹配条件，返回 handle 为 nil，状态码由匹配条件决定，见 Mux.When
+func (m *Mux) getHandler(r *http.Request, method, path string) (http.Handler, *Context, methodType, errCode) {
+	lastNode, ps := m.findMatchedNode(method, path)
+	if lastNode == nil {
+		return nil, ps, 0, NotFound
 	}
 
-	mCode := methodMap[method]
-	lastNode := m.nodes[idx]
+	mCode := m.load().methods.codes[method]
+	allow := m.allowMethods(lastNode.allowMethods)
 	if lastNode.allowMethods&mCode == 0 {
-		return nil, ps, NotAllowed
+		switch {
+		case mCode == mOPTION && m.autoOptions:
+			if m.optionsHandler != nil {
+				return m.optionsHandler, ps, allow, 0
+			}
+			return http.HandlerFunc(optionsHandler), ps, allow, 0
+		case mCode == mHEAD && m.autoHead && lastNode.allowMethods&mGET != 0:
+			rt, code := selectRoute(r, lastNode.funcMap[mGET])
+			if rt == nil {
+				return nil, ps, allow, statusCode(code)
+			}
+			return headHandler(rt), m.withRoute(r, ps, rt), allow, 0
+		}
+		return nil, ps, allow, NotAllowed
+	}
+	rt, code := selectRoute(r, lastNode.funcMap[mCode])
+	if rt == nil {
+		return nil, ps, allow, statusCode(code)
+	}
+	return rt, m.withRoute(r, ps, rt), allow, 0
+}
+
+// withRoute 把匹配到的路由放到 ctx 中。ctx 为 nil 时，只有路由有元数据或中间件，
+// 或者请求中已经有 Context（根路由的中间件、Mount）时才从 contextPool 中获取，
+// 否则没有代码会读取 Context，返回 nil，没有参数的静态路由处理请求时不需要分配内存
+func (m *Mux) withRoute(r *http.Request, ctx *Context, rt *Route) *Context {
+	if ctx == nil {
+		if !rt.needContext() && r.Context().Value(contextKeyValue) == nil {
+			return nil
+		}
+		ctx = getContext(m.contextPool)
+	}
+	ctx.route = rt
+	return ctx
+}
+
+// statusCode 把匹配条件的 HTTP 状态码转换成 errCode
+func statusCode(status int) errCode {
+	switch status {
+	case http.StatusNotAcceptable:
+		return NotAcceptable
+	case http.StatusUnsupportedMediaType:
+		return UnsupportedMediaType
+	}
+	return NotFound
+}
+
+// allowMethods 在节点注册的方法之外，加上自动处理的 OPTIONS 和 HEAD
+func (m *Mux) allowMethods(mt methodType) methodType {
+	if m.autoOptions {
+		mt |= mOPTION
+	}
+	if m.autoHead && mt&mGET != 0 {
+		mt |= mHEAD
+	}
+	return mt
+}
+
+// optionsHandler 默认的 OPTIONS handler，Allow 头已经在 routeHTTP 中设置好
+func optionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// headHandler 用 GET 的 handler 处理 HEAD 请求，丢弃响应体
+func headHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(headResponseWriter{w}, r)
+	})
+}
+
+// headResponseWriter 丢弃写入的响应体，只保留响应头和状态码
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w headResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// NotFound 设置找不到路由时使用的 handler，默认为 http.NotFound
+// 子路由和根路由共用同一个设置
+func (m *Mux) NotFound(handler http.Handler) {
+	m.notFound = handler
+}
+
+// MethodNotAllowed 设置路径存在但 HTTP Method 不匹配时使用的 handler
+// 调用 handler 之前响应中已经设置好了 Allow 头，默认返回纯文本的 405
+// 子路由和根路由共用同一个设置
+func (m *Mux) MethodNotAllowed(handler http.Handler) {
+	m.methodNotAllowed = handler
+}
+
+// methodNotAllowedHandler 默认的 405 handler
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	http.Error(
+		w,
+		http.StatusText(http.StatusMethodNotAllowed),
+		http.StatusMethodNotAllowed,
+	)
+}
+
+// matches 判断 path 能否匹配到注册了 method 并且满足匹配条件的路由
+func (m *Mux) matches(r *http.Request, method, path string) bool {
+	handle, ctx, _, _ := m.getHandler(r, method, path)
+	putContext(m.contextPool, ctx)
+	return handle != nil
+}
+
+// canonicalPath 返回 path 匹配到的路由中静态文本使用注册时的大小写后的路径，参数值保持不变
+// 找不到路由或者大小写已经和注册的路由一致时返回空字符串
+func (m *Mux) canonicalPath(path string) string {
+	tb := m.load()
+	if len(tb.nodes) == 0 {
+		return ""
+	}
+	trimmed := path
+	for !tb.strictSlash && len(trimmed) > 1 && trimmed[len(trimmed)-1] == '/' {
+		trimmed = trimmed[:len(trimmed)-1]
+	}
+	idx, ctx := tb.matchNode(0, trimmed, nil)
+	if idx == -1 {
+		putContext(m.contextPool, ctx)
+		return ""
+	}
+
+	parts := make([]string, 0, 8)
+	values := []string(nil)
+	if ctx != nil {
+		values = ctx.URLParams.Values
+	}
+	for i, j := idx, len(values)-1; i != 0; i = tb.nodes[i].parent {
+		if n := tb.nodes[i]; n.kind == staticNode {
+			parts = append(parts, n.prefix)
+		} else {
+			parts = append(parts, values[j])
+			j--
+		}
+	}
+	putContext(m.contextPool, ctx)
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	canonical := strings.Join(parts, "")
+	if !tb.strictSlash && len(canonical) > 1 {
+		canonical = strings.TrimRight(canonical, "/")
+	}
+	if canonical == trimmed || !equalFoldASCII(canonical, trimmed) {
+		return ""
+	}
+	return canonical + path[len(trimmed):]
+}
+
+// toggleSlash 去掉或加上 path 末尾的 '/'，path 为 "/" 时返回空字符串
+func toggleSlash(path string) string {
+	switch {
+	case path == "/":
+		return ""
+	case strings.HasSuffix(path, "/"):
+		return path[:len(path)-1]
 	}
-	return *lastNode.funcMap[mCode], ps, 0
+	return path + "/"
 }
 
-// ServeHTTP
+// cleanPath 和 http.ServeMux 一样用 path.Clean 规范路径，保留末尾的 '/'
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	np := path.Clean(p)
+	if p[len(p)-1] == '/' && np != "/" {
+		np += "/"
+	}
+	return np
+}
+
+// redirect 重定向到 path，rawPath 为 path 编码后的形式，可以为空，保留请求中的 query
+// path 开头的多个 '/' 合并成一个，避免 "//host" 被当成其他站点
+func redirect(w http.ResponseWriter, r *http.Request, path, rawPath string, code int) {
+	u := url.URL{Path: "/" + strings.TrimLeft(path, "/"), RawQuery: r.URL.RawQuery}
+	if rawPath != "" {
+		u.RawPath = "/" + strings.TrimLeft(rawPath, "/")
+	}
+	http.Redirect(w, r, u.String(), code)
+}
+
+// rawRoutePath 把 EscapedPath 中除了 "%2F" 和 "%25" 以外的转义字符还原，
+// 这样静态文本可以直接和注册的路由比较，编码的 '/' 也不会被当作分隔符
+func rawRoutePath(escaped string) (string, error) {
+	i := strings.IndexByte(escaped, '%')
+	if i == -1 {
+		return escaped, nil
+	}
+	b := make([]byte, 0, len(escaped))
+	b = append(b, escaped[:i]...)
+	for ; i < len(escaped); i++ {
+		if escaped[i] != '%' {
+			b = append(b, escaped[i])
+			continue
+		}
+		if i+2 >= len(escaped) || !isHex(escaped[i+1]) || !isHex(escaped[i+2]) {
+			return "", errors.New("invalid escape in path " + escaped)
+		}
+		switch c := unhex(escaped[i+1])<<4 | unhex(escaped[i+2]); c {
+		case '/':
+			b = append(b, "%2F"...)
+		case '%':
+			b = append(b, "%25"...)
+		default:
+			b = append(b, c)
+		}
+		i += 2
+	}
+	return string(b), nil
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c <= '9':
+		return c - '0'
+	case c <= 'F':
+		return c - 'A' + 10
+	}
+	return c - 'a' + 10
+}
+
+// unescapeParams 开启 WithRawPath 时，依次还原每个参数值中的转义字符
+func unescapeParams(ctx *Context) {
+	for i, v := range ctx.URLParams.Values {
+		if strings.IndexByte(v, '%') == -1 {
+			continue
+		}
+		if u, err := url.PathUnescape(v); err == nil {
+			ctx.URLParams.Values[i] = u
+		}
+	}
+}
+
+// ServeHTTP 先经过根路由的中间件，再进行路由匹配
+// 根路由有中间件时，请求中的 Context 在经过中间件之前就已经放入，路由匹配之后再填入匹配结果，
+// 所以根路由的中间件在下一个 handler 返回之后也可以读取匹配到的路由和 URL 参数
 func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	root := m.root()
+	if len(root.middlewares) == 0 {
+		root.handler.ServeHTTP(w, r)
+		return
+	}
+	ctx := getContext(root.contextPool)
+	ctx.owner = root
+	defer putContext(root.contextPool, ctx)
+	parent, _ := r.Context().Value(contextKeyValue).(*Context)
+	// 由 Mount 挂载的 Mux 处理时，路由路径和 URL 参数加上外层的
+	mounted := parent != nil && ctx.inheritMount(parent)
+	root.handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKeyValue, ctx)))
+	if mounted {
+		// 把匹配结果写回外层，外层根路由的中间件读取到的是内层完整的路由路径
+		parent.copyFrom(ctx)
+	}
+}
+
+// routeHTTP 根据请求匹配路由并处理
+func (m *Mux) routeHTTP(w http.ResponseWriter, r *http.Request) {
 	method, path := r.Method, r.URL.Path
-	handle, ctx, code := m.getHandler(method, path)
+	if m.cleanPath != 0 && method != http.MethodConnect {
+		if m.rawPath {
+			// 在编码后的路径上规范，"%2F" 是参数值的一部分，不是分隔符
+			escaped := r.URL.EscapedPath()
+			if clean := cleanPath(escaped); clean != escaped {
+				unescaped, _ := url.PathUnescape(clean)
+				redirect(w, r, unescaped, clean, m.cleanPath)
+				return
+			}
+		} else if clean := cleanPath(path); clean != path {
+			redirect(w, r, clean, "", m.cleanPath)
+			return
+		}
+	}
+	if m.rawPath {
+		var err error
+		if path, err = rawRoutePath(r.URL.EscapedPath()); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+	}
+	target, handle, ctx, allow, code := m.selectHandler(r, method, path)
+	methods := target.load().methods
+	if handle == nil && m.redirectSlash != 0 && method != http.MethodConnect {
+		if alt := toggleSlash(path); alt != "" && target.matches(r, method, alt) {
+			putContext(m.contextPool, ctx)
+			rawPath := r.URL.RawPath
+			if rawPath != "" {
+				rawPath = toggleSlash(rawPath)
+			}
+			redirect(w, r, toggleSlash(r.URL.Path), rawPath, m.redirectSlash)
+			return
+		}
+	}
+	if handle != nil && m.caseRedirect != 0 && method != http.MethodConnect {
+		if canonical := target.canonicalPath(path); canonical != "" {
+			putContext(m.contextPool, ctx)
+			if m.rawPath {
+				unescaped, _ := url.PathUnescape(canonical)
+				redirect(w, r, unescaped, canonical, m.caseRedirect)
+			} else {
+				redirect(w, r, canonical, "", m.caseRedirect)
+			}
+			return
+		}
+	}
+	if m.rawPath && ctx != nil {
+		unescapeParams(ctx)
+	}
 	if ctx != nil {
-		r = r.WithContext(context.WithValue(r.Context(), ContextKey, ctx))
-		defer m.contextPool.Put(ctx)
+		if holder, _ := r.Context().Value(contextKeyValue).(*Context); holder != nil && holder.owner == m {
+			// 填入 ServeHTTP 放入的 Context，根路由的中间件也可以读取
+			holder.fill(ctx)
+			putContext(m.contextPool, ctx)
+		} else {
+			r = r.WithContext(context.WithValue(r.Context(), contextKeyValue, ctx))
+			defer putContext(m.contextPool, ctx)
+			// 由 Mount 挂载的 Mux 处理时，路由路径和 URL 参数加上外层的，
+			// 处理完后把匹配结果写回外层，外层根路由的中间件读取到的是内层完整的路由路径
+			if holder != nil && ctx.inheritMount(holder) {
+				defer holder.copyFrom(ctx)
+			}
+		}
 	}
 
 	switch code {
 	case NotFound:
+		if m.notFound != nil {
+			m.notFound.ServeHTTP(w, r)
+			return
+		}
 		http.NotFound(w, r)
 	case NotAllowed:
-		http.Error(
-			w,
-			http.StatusText(http.StatusMethodNotAllowed),
-			http.StatusMethodNotAllowed,
-		)
+		w.Header().Set("Allow", strings.Join(methods.list(allow), ", "))
+		if m.methodNotAllowed != nil {
+			m.methodNotAllowed.ServeHTTP(w, r)
+			return
+		}
+		methodNotAllowedHandler(w, r)
+	case NotAcceptable:
+		http.Error(w, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable)
+	case UnsupportedMediaType:
+		http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
 	default:
+		if method == http.MethodOptions {
+			w.Header().Set("Allow", strings.Join(methods.list(allow), ", "))
+		}
 		handle.ServeHTTP(w, r)
 	}
 }
@@ -1,6 +1,7 @@
 package chu
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -21,6 +22,22 @@ func catchPanic(f func()) (rec interface{}) {
 	return
 }
 
+// urlParams 按照 *Context 原来的打印格式返回请求中的 URL 参数，没有参数时返回 "<nil>"
+func urlParams(r *http.Request) string {
+	ctx, _ := r.Context().Value(ContextKey).(*Context)
+	if ctx == nil || len(ctx.URLParams.Keys) == 0 {
+		return "<nil>"
+	}
+	return fmt.Sprintf("&{%v}", ctx.URLParams)
+}
+
+// namedHandler 返回的 handler 输出 name 和 urlParams(r)，用来检查请求匹配到的路由和参数
+func namedHandler(name string) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, name, " ", urlParams(r))
+	}
+}
+
 type testRouter struct {
 	path, method string
 	handlefunc   http.HandlerFunc
@@ -69,6 +86,16 @@ func TestHandle(t *testing.T) {
 			method:     "DELETE",
 			handlefunc: fakeHandlerFunc(),
 		},
+		{
+			path:       "/api/abc",
+			method:     "POST",
+			handlefunc: fakeHandlerFunc(),
+		},
+		{
+			path:       "/book/info",
+			method:     "GET",
+			handlefunc: fakeHandlerFunc(),
+		},
 	}
 	mux := New()
 	for _, test := range tests {
@@ -84,16 +111,6 @@ func TestHandle(t *testing.T) {
 			method:     "GET",
 			handlefunc: fakeHandlerFunc(),
 		},
-		{
-			path:       "/api/abc",
-			method:     "POST",
-			handlefunc: fakeHandlerFunc(),
-		},
-		{
-			path:       "/book/info",
-			method:     "GET",
-			handlefunc: fakeHandlerFunc(),
-		},
 		{
 			path:       "/book/:ids",
 			method:     "GET",
@@ -199,7 +216,664 @@ func TestMux(t *testing.T) {
 	rw = httptest.NewRecorder()
 	req, _ = http.NewRequest(http.MethodPost, "/ping", nil)
 	mux.ServeHTTP(rw, req)
+	if code := rw.Result().StatusCode; code != http.StatusNotFound {
+		t.Errorf("expect 404 status code, got %v", code)
+	}
+	if allow := rw.Header().Get("Allow"); allow != "" {
+		t.Errorf("expect no Allow header, got %#v", allow)
+	}
+}
+
+func TestMethods(t *testing.T) {
+	handle := func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, r.Method)
+	}
+	mux := New(WithMethods("PROPFIND", "MKCOL", "PROPFIND"))
+	mux.Patch("/items/:id", handle)
+	mux.Options("/items/:id", handle)
+	mux.Connect("/tunnel", handle)
+	mux.Trace("/trace", handle)
+	mux.Match([]string{http.MethodGet, "PROPFIND"}, "/dav/:file", handle)
+	mux.Any("/any", handle)
+
+	dav := New(WithMethods("PROPFIND"))
+	dav.Handle("PROPFIND", "/props", http.HandlerFunc(handle))
+	mux.Mount("/webdav", dav)
+
+	for _, tt := range []struct {
+		method, path string
+		code         int
+	}{
+		{http.MethodPatch, "/items/1", http.StatusOK},
+		{http.MethodOptions, "/items/1", http.StatusOK},
+		{http.MethodConnect, "/tunnel", http.StatusOK},
+		{http.MethodTrace, "/trace", http.StatusOK},
+		{http.MethodGet, "/dav/a.txt", http.StatusOK},
+		{"PROPFIND", "/dav/a.txt", http.StatusOK},
+		{"MKCOL", "/dav/a.txt", http.StatusMethodNotAllowed},
+		{"PURGE", "/dav/a.txt", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/any", http.StatusOK},
+		{"MKCOL", "/any", http.StatusOK},
+		{"PROPFIND", "/webdav/props", http.StatusOK},
+	} {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		mux.ServeHTTP(rw, req)
+		if rw.Code != tt.code {
+			t.Errorf("%s %s: expect %v status code, got %v", tt.method, tt.path, tt.code, rw.Code)
+		}
+		if tt.code == http.StatusOK && rw.Body.String() != tt.method {
+			t.Errorf("%s %s: expect %#v, got %#v", tt.method, tt.path, tt.method, rw.Body.String())
+		}
+	}
+
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, httptest.NewRequest(http.MethodPut, "/dav/a.txt", nil))
+	if allow := rw.Header().Get("Allow"); allow != "GET, PROPFIND" {
+		t.Errorf("expect Allow header %#v, got %#v", "GET, PROPFIND", allow)
+	}
+
+	if err := mux.TryHandle("PURGE", "/cache", fakeHandlerFunc()); !errors.Is(err, ErrUnknownMethod) {
+		t.Errorf("TryHandle() with unregistered method = %v, want ErrUnknownMethod", err)
+	}
+	rec := catchPanic(func() {
+		New(WithMethods("BAD METHOD"))
+	})
+	if err, ok := rec.(error); !ok || !errors.Is(err, ErrUnknownMethod) {
+		t.Errorf("WithMethods() with invalid method: expect panic with ErrUnknownMethod, got %v", rec)
+	}
+	many := make([]string, maxMethods)
+	for i := range many {
+		many[i] = fmt.Sprintf("M%d", i)
+	}
+	if rec := catchPanic(func() { New(WithMethods(many...)) }); rec == nil {
+		t.Errorf("WithMethods() with too many methods should panic")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	mux := New(WithMethods("PROPFIND"))
+	mux.Get("/login", namedHandler("get"))
+
+	routes := mux.Match([]string{http.MethodPost, http.MethodPut}, "/login", namedHandler("post"))
+	var got []string
+	for _, rt := range routes {
+		got = append(got, rt.method+" "+rt.fullPattern)
+	}
+	if want := []string{"POST /login", "PUT /login"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Match() = %v, want %v", got, want)
+	}
+	routes[0].Name("login")
+	if url, err := mux.URL("login"); err != nil || url != "/login" {
+		t.Errorf("URL() = %v, %v, want /login", url, err)
+	}
+	if routes := mux.Any("/any", namedHandler("any")); len(routes) != 10 || routes[9].method != "PROPFIND" {
+		t.Errorf("Any() returns %d routes, want 10 ending with PROPFIND", len(routes))
+	}
+
+	// 有一个 Method 不能注册时，其他 Method 也不会注册
+	for _, tt := range []struct {
+		methods []string
+		err     error
+	}{
+		{[]string{http.MethodDelete, "PURGE"}, ErrUnknownMethod},
+		{[]string{http.MethodDelete, http.MethodGet}, ErrDuplicateRoute},
+		{[]string{http.MethodDelete, http.MethodDelete}, ErrDuplicateRoute},
+	} {
+		rec := catchPanic(func() {
+			mux.Match(tt.methods, "/login", namedHandler("delete"))
+		})
+		if err, ok := rec.(error); !ok || !errors.Is(err, tt.err) {
+			t.Errorf("Match(%v): expect panic with %v, got %v", tt.methods, tt.err, rec)
+		}
+		rw := httptest.NewRecorder()
+		mux.ServeHTTP(rw, httptest.NewRequest(http.MethodDelete, "/login", nil))
+		if rw.Code != http.StatusMethodNotAllowed {
+			t.Errorf("DELETE /login after Match(%v): expect 405 status code, got %v", tt.methods, rw.Code)
+		}
+	}
+}
+
+func TestCatchAll(t *testing.T) {
+	mux := New()
+	mux.Get("/static/*filepath", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, URLParam(r, "filepath"))
+	})
+	mux.Get("/proxy/:host/*rest", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, URLParam(r, "host"), " ", URLParam(r, "rest"))
+	})
+
+	tests := []struct {
+		path, want string
+	}{
+		{"/static/app.js", "app.js"},
+		{"/static/css/main.css", "css/main.css"},
+		{"/static/", ""},
+		{"/proxy/example.com/a/b/c", "example.com a/b/c"},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, tt.path, nil)
+		mux.ServeHTTP(rw, req)
+		if code := rw.Result().StatusCode; code != http.StatusOK {
+			t.Errorf("%s: expect 200 status code, got %v", tt.path, code)
+		}
+		if got := rw.Body.String(); got != tt.want {
+			t.Errorf("%s: expect %#v, got %#v", tt.path, tt.want, got)
+		}
+	}
+
+	// 同一位置不能有两个不同名字的通配节点
+	conflictTests := []testRouter{
+		{path: "/static/*other", method: "GET", handlefunc: fakeHandlerFunc()},
+	}
+	conflictPanicReg := regexp.MustCompile(`Conflict between .* and .*`)
+	for _, ct := range conflictTests {
+		rec := catchPanic(func() {
+			mux.Handle(ct.method, ct.path, ct.handlefunc)
+		})
+		if recstr := fmt.Sprint(rec); !conflictPanicReg.MatchString(recstr) {
+			t.Errorf("got panic msg: '%s', but want 'Conflict between ... and ...'", recstr)
+		}
+	}
+}
+
+func TestMatchPriority(t *testing.T) {
+	mux := New()
+	mux.Get("/users/me", namedHandler("me"))
+	mux.Get("/users/:id", namedHandler("user"))
+	mux.Get("/users/:id/profile", namedHandler("profile"))
+	mux.Get("/users/me/settings/:key", namedHandler("settings"))
+	mux.Get("/users/*rest", namedHandler("rest"))
+
+	tests := []struct {
+		path, want string
+	}{
+		{"/users/me", "me <nil>"},
+		{"/users/42", "user &{{[id] [42]}}"},
+		{"/users/me/profile", "profile &{{[id] [me]}}"},
+		{"/users/me/settings/theme", "settings &{{[key] [theme]}}"},
+		{"/users/me/settings", "rest &{{[rest] [me/settings]}}"},
+		{"/users/42/posts", "rest &{{[rest] [42/posts]}}"},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, tt.path, nil)
+		mux.ServeHTTP(rw, req)
+		if got := rw.Body.String(); got != tt.want {
+			t.Errorf("%s: expect %#v, got %#v", tt.path, tt.want, got)
+		}
+	}
+
+	rw := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/books", nil)
+	mux.ServeHTTP(rw, req)
+	if code := rw.Result().StatusCode; code != http.StatusNotFound {
+		t.Errorf("expect 404 status code, got %v", code)
+	}
+}
+
+func TestParamConstraint(t *testing.T) {
+	mux := New()
+	mux.Get("/orders/:id<[0-9]+>", namedHandler("order"))
+	mux.Get("/posts/:id{int}", namedHandler("id"))
+	mux.Get("/posts/:uuid{uuid}", namedHandler("uuid"))
+	mux.Get("/posts/:slug", namedHandler("slug"))
+	mux.Get("/colors/:name{alpha}/:rgb{hex}", namedHandler("color"))
+
+	tests := []struct {
+		path, want string
+	}{
+		{"/orders/42", "order &{{[id] [42]}}"},
+		{"/posts/42", "id &{{[id] [42]}}"},
+		{"/posts/0b7e1b6e-8f4c-4d6e-9a43-1f2d3c4b5a69", "uuid &{{[uuid] [0b7e1b6e-8f4c-4d6e-9a43-1f2d3c4b5a69]}}"},
+		{"/posts/hello-world", "slug &{{[slug] [hello-world]}}"},
+		{"/colors/red/ff0000", "color &{{[name rgb] [red ff0000]}}"},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, tt.path, nil)
+		mux.ServeHTTP(rw, req)
+		if got := rw.Body.String(); got != tt.want {
+			t.Errorf("%s: expect %#v, got %#v", tt.path, tt.want, got)
+		}
+	}
+
+	for _, path := range []string{"/orders/abc", "/orders/42a", "/colors/red/zz", "/colors/r3d/ff"} {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		mux.ServeHTTP(rw, req)
+		if code := rw.Result().StatusCode; code != http.StatusNotFound {
+			t.Errorf("%s: expect 404 status code, got %v", path, code)
+		}
+	}
+}
+
+func TestMultiParamSegment(t *testing.T) {
+	mux := New()
+	mux.Get("/files/:name.:ext", namedHandler("file"))
+	mux.Get("/files/:name", namedHandler("name"))
+	mux.Get("/archive/:year{int}-:month{int}", namedHandler("month"))
+	mux.Get("/archive/:year{int}-:month{int}/:slug.html", namedHandler("post"))
+	mux.Get("/v:major.:minor/docs", namedHandler("docs"))
+
+	tests := []struct {
+		path, want string
+	}{
+		{"/files/readme.md", "file &{{[name ext] [readme md]}}"},
+		{"/files/archive.tar.gz", "file &{{[name ext] [archive tar.gz]}}"},
+		{"/files/readme", "name &{{[name] [readme]}}"},
+		{"/files/.bashrc", "name &{{[name] [.bashrc]}}"},
+		{"/archive/2021-08", "month &{{[year month] [2021 08]}}"},
+		{"/archive/2021-08/hello.world.html", "post &{{[year month slug] [2021 08 hello.world]}}"},
+		{"/v1.2/docs", "docs &{{[major minor] [1 2]}}"},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, tt.path, nil)
+		mux.ServeHTTP(rw, req)
+		if got := rw.Body.String(); got != tt.want {
+			t.Errorf("%s: expect %#v, got %#v", tt.path, tt.want, got)
+		}
+	}
+
+	for _, path := range []string{"/archive/2021", "/archive/2021-", "/archive/abc-08", "/v1/docs", "/v.2/docs"} {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		mux.ServeHTTP(rw, req)
+		if code := rw.Result().StatusCode; code != http.StatusNotFound {
+			t.Errorf("%s: expect 404 status code, got %v", path, code)
+		}
+	}
+
+	// 同一位置上没有约束的参数名字不同时冲突，即使后面的静态文本不同
+	for _, path := range []string{"/files/:file", "/files/:file-:version"} {
+		if err := mux.TryHandle(http.MethodGet, path, fakeHandlerFunc()); !errors.Is(err, ErrConflict) {
+			t.Errorf("%s: expect ErrConflict, got %v", path, err)
+		}
+	}
+	if err := mux.TryHandle(http.MethodGet, "/files/:name-:version", fakeHandlerFunc()); err != nil {
+		t.Errorf("/files/:name-:version: %v", err)
+	}
+}
+
+func TestOptionalSegments(t *testing.T) {
+	mux := New()
+	mux.Get("/reports/:year{int}/:month{int}?/:day{int}?", func(rw http.ResponseWriter, r *http.Request) {
+		month, ok := LookupURLParam(r, "month")
+		fmt.Fprint(rw, URLParam(r, "year"), " ", month, " ", ok, " ", URLParam(r, "day"))
+	})
+	mux.Get("/docs/latest?", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "docs")
+	})
+
+	tests := []struct {
+		path, want string
+	}{
+		{"/reports/2021", "2021  false "},
+		{"/reports/2021/", "2021  false "},
+		{"/reports/2021/08", "2021 08 true "},
+		{"/reports/2021/08/31", "2021 08 true 31"},
+		{"/docs", "docs"},
+		{"/docs/latest", "docs"},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, tt.path, nil)
+		mux.ServeHTTP(rw, req)
+		if got := rw.Body.String(); got != tt.want {
+			t.Errorf("%s: expect %#v, got %#v", tt.path, tt.want, got)
+		}
+	}
+	for _, path := range []string{"/report", "/reports/2021/aug", "/reports/2021/08/31/extra"} {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		mux.ServeHTTP(rw, req)
+		if code := rw.Result().StatusCode; code != http.StatusNotFound {
+			t.Errorf("%s: expect 404 status code, got %v", path, code)
+		}
+	}
+
+	// 展开后的路由和已有路由重复时整个注册失败，可选段只能出现在末尾
+	errTests := []struct {
+		path string
+		want error
+	}{
+		{"/docs/:page?", ErrDuplicateRoute},
+		{"/a/:b?/c", ErrInvalidPath},
+		{"/static/*file?", ErrInvalidPath},
+	}
+	for _, tt := range errTests {
+		if err := mux.TryHandle(http.MethodGet, tt.path, fakeHandlerFunc()); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expect %v, got %v", tt.path, tt.want, err)
+		}
+	}
+	if got := len(mux.Routes()); got != 2 {
+		t.Errorf("expect 2 routes, got %d: %v", got, mux.Routes())
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	mux := New()
+	mux.Get("/ping", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "pong")
+	})
+	admin := mux.Route("/admin")
+	admin.Get("/users", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "users")
+	})
+
+	// 在注册路由之后添加的中间件同样生效
+	mux.Use(headerMiddleware("X-Order", "global"))
+	admin.Use(headerMiddleware("X-Order", "admin"))
+
+	tests := []struct {
+		method, path string
+		code         int
+		order        []string
+	}{
+		{http.MethodGet, "/ping", http.StatusOK, []string{"global"}},
+		{http.MethodGet, "/admin/users", http.StatusOK, []string{"global", "admin"}},
+		{http.MethodGet, "/not/found", http.StatusNotFound, []string{"global"}},
+		{http.MethodPost, "/ping", http.StatusMethodNotAllowed, []string{"global"}},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest(tt.method, tt.path, nil)
+		mux.ServeHTTP(rw, req)
+		if code := rw.Result().StatusCode; code != tt.code {
+			t.Errorf("%s %s: expect %v status code, got %v", tt.method, tt.path, tt.code, code)
+		}
+		if got := rw.Header().Values("X-Order"); fmt.Sprint(got) != fmt.Sprint(tt.order) {
+			t.Errorf("%s %s: expect middlewares %v, got %v", tt.method, tt.path, tt.order, got)
+		}
+	}
+}
+
+func TestCustomErrorHandlers(t *testing.T) {
+	mux := New()
+	mux.Get("/book/:id", fakeHandlerFunc())
+	mux.Put("/book/:id", fakeHandlerFunc())
+	mux.Delete("/book/:id", fakeHandlerFunc())
+
+	// 默认的 405 也要带上 Allow 头
+	rw := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/book/1", nil)
+	mux.ServeHTTP(rw, req)
 	if code := rw.Result().StatusCode; code != http.StatusMethodNotAllowed {
 		t.Errorf("expect 405 status code, got %v", code)
 	}
+	if allow, want := rw.Header().Get("Allow"), "GET, PUT, DELETE"; allow != want {
+		t.Errorf("expect Allow header %#v, got %#v", want, allow)
+	}
+
+	jsonError := func(code int) http.HandlerFunc {
+		return func(rw http.ResponseWriter, r *http.Request) {
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(code)
+			fmt.Fprintf(rw, `{"error":%q}`, http.StatusText(code))
+		}
+	}
+	mux.NotFound(jsonError(http.StatusNotFound))
+	mux.MethodNotAllowed(jsonError(http.StatusMethodNotAllowed))
+
+	tests := []struct {
+		method, path, body, allow string
+		code                      int
+	}{
+		{http.MethodGet, "/nothing", `{"error":"Not Found"}`, "", http.StatusNotFound},
+		// 只是其他路由的中间节点，没有注册任何方法，返回 404 而不是 Allow 为空的 405
+		{http.MethodGet, "/book", `{"error":"Not Found"}`, "", http.StatusNotFound},
+		{http.MethodGet, "/book/", `{"error":"Not Found"}`, "", http.StatusNotFound},
+		{http.MethodPost, "/book/1", `{"error":"Method Not Allowed"}`, "GET, PUT, DELETE", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest(tt.method, tt.path, nil)
+		mux.ServeHTTP(rw, req)
+		if code := rw.Result().StatusCode; code != tt.code {
+			t.Errorf("%s %s: expect %v status code, got %v", tt.method, tt.path, tt.code, code)
+		}
+		if got := rw.Body.String(); got != tt.body {
+			t.Errorf("%s %s: expect %#v, got %#v", tt.method, tt.path, tt.body, got)
+		}
+		if got := rw.Header().Get("Allow"); got != tt.allow {
+			t.Errorf("%s %s: expect Allow header %#v, got %#v", tt.method, tt.path, tt.allow, got)
+		}
+	}
+}
+
+// largeRouteTable 注册 n 组路由，每组包含静态、参数和通配三个路由
+func largeRouteTable(n int, opts ...Option) *Mux {
+	mux := New(opts...)
+	for i := 0; i < n; i++ {
+		prefix := fmt.Sprintf("/svc%d", i)
+		mux.Get(prefix+"/items", fakeHandlerFunc())
+		mux.Get(prefix+"/items/:id", fakeHandlerFunc())
+		mux.Get(prefix+"/files/*filepath", fakeHandlerFunc())
+	}
+	return mux
+}
+
+func TestLargeRouteTable(t *testing.T) {
+	n := 3400
+	for _, mux := range []*Mux{largeRouteTable(n), largeRouteTable(n, WithCapacity(3*n))} {
+		if got := len(mux.Routes()); got != 3*n {
+			t.Fatalf("expect %v routes, got %v", 3*n, got)
+		}
+		for _, i := range []int{0, n / 2, n - 1} {
+			for _, path := range []string{
+				fmt.Sprintf("/svc%d/items", i),
+				fmt.Sprintf("/svc%d/items/42", i),
+				fmt.Sprintf("/svc%d/files/a/b.txt", i),
+			} {
+				rw := httptest.NewRecorder()
+				req, _ := http.NewRequest(http.MethodGet, path, nil)
+				mux.ServeHTTP(rw, req)
+				if code := rw.Result().StatusCode; code != http.StatusOK {
+					t.Errorf("%s: expect 200 status code, got %v", path, code)
+				}
+			}
+		}
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("/svc%d/items", n), nil)
+		mux.ServeHTTP(rw, req)
+		if code := rw.Result().StatusCode; code != http.StatusNotFound {
+			t.Errorf("expect 404 status code, got %v", code)
+		}
+	}
+}
+
+func BenchmarkRegister10kRoutes(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		largeRouteTable(3400)
+	}
+}
+
+func BenchmarkRegister10kRoutesWithCapacity(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		largeRouteTable(3400, WithCapacity(10200))
+	}
+}
+
+func BenchmarkLargeRouteTable(b *testing.B) {
+	mux := largeRouteTable(3400)
+	paths := []string{"/svc3399/items", "/svc1700/items/42", "/svc1/files/a/b.txt"}
+	reqs := make([]*http.Request, len(paths))
+	for i, path := range paths {
+		reqs[i], _ = http.NewRequest(http.MethodGet, path, nil)
+	}
+	rw := httptest.NewRecorder()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mux.ServeHTTP(rw, reqs[i%len(reqs)])
+	}
+}
+
+// benchRoutes 用于基准测试和内存分配测试的路由
+var benchRoutes = []string{
+	"/",
+	"/users",
+	"/users/:user",
+	"/users/:user/repos",
+	"/users/:user/received_events/public",
+	"/uploads/:owner/:repo/releases/:id/assets/*name",
+	"/repos/:owner/:repo/issues/:number/comments",
+	"/repos/:owner/:repo/pulls/:number{int}/files",
+	"/search/repositories",
+	"/search/code",
+	"/static/*filepath",
+}
+
+func benchMux() *Mux {
+	mux := New()
+	for _, path := range benchRoutes {
+		mux.Get(path, fakeHandlerFunc())
+	}
+	return mux
+}
+
+func TestFindMatchedNodeAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops objects randomly with -race")
+	}
+	mux := benchMux()
+	paths := []string{
+		"/users",
+		"/search/code",
+		"/users/alacine",
+		"/repos/alacine/chu/pulls/42/files",
+		"/uploads/alacine/chu/releases/1/assets/a/b.tar.gz",
+	}
+	for _, path := range paths {
+		allocs := testing.AllocsPerRun(100, func() {
+			n, ctx := mux.findMatchedNode(http.MethodGet, path)
+			if n == nil {
+				t.Fatalf("%s: expect to match a route", path)
+			}
+			if ctx != nil {
+				mux.contextPool.Put(ctx)
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("%s: expect no allocation, got %v", path, allocs)
+		}
+	}
+}
+
+func TestServeHTTPAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops objects randomly with -race")
+	}
+	mux := benchMux()
+	rw := httptest.NewRecorder()
+	// 没有参数、元数据和中间件的静态路由不放入 Context
+	for _, path := range []string{"/users", "/search/code", "/search/repositories"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		allocs := testing.AllocsPerRun(100, func() {
+			mux.ServeHTTP(rw, req)
+		})
+		if allocs != 0 {
+			t.Errorf("%s: expect no allocation, got %v", path, allocs)
+		}
+	}
+}
+
+func benchmarkFindMatchedNode(b *testing.B, path string) {
+	mux := benchMux()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ctx := mux.findMatchedNode(http.MethodGet, path); ctx != nil {
+			mux.contextPool.Put(ctx)
+		}
+	}
+}
+
+func BenchmarkMatchStatic(b *testing.B) {
+	benchmarkFindMatchedNode(b, "/search/repositories")
+}
+
+func BenchmarkMatchParam(b *testing.B) {
+	benchmarkFindMatchedNode(b, "/users/alacine/repos")
+}
+
+func BenchmarkMatchParams(b *testing.B) {
+	benchmarkFindMatchedNode(b, "/repos/alacine/chu/issues/42/comments")
+}
+
+func BenchmarkMatchConstraint(b *testing.B) {
+	benchmarkFindMatchedNode(b, "/repos/alacine/chu/pulls/42/files")
+}
+
+func BenchmarkMatchCatchAll(b *testing.B) {
+	benchmarkFindMatchedNode(b, "/static/css/main.css")
+}
+
+func BenchmarkMatchNotFound(b *testing.B) {
+	benchmarkFindMatchedNode(b, "/users/alacine/nothing/here")
+}
+
+func benchmarkServeHTTP(b *testing.B, handler http.Handler, path string) {
+	req, _ := http.NewRequest(http.MethodGet, path, nil)
+	rw := httptest.NewRecorder()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		handler.ServeHTTP(rw, req)
+	}
+}
+
+func BenchmarkServeStatic(b *testing.B) {
+	benchmarkServeHTTP(b, benchMux(), "/search/repositories")
+}
+
+func BenchmarkServeParam(b *testing.B) {
+	benchmarkServeHTTP(b, benchMux(), "/users/alacine/repos")
+}
+
+func BenchmarkServeParams(b *testing.B) {
+	benchmarkServeHTTP(b, benchMux(), "/repos/alacine/chu/issues/42/comments")
+}
+
+func BenchmarkServeCatchAll(b *testing.B) {
+	benchmarkServeHTTP(b, benchMux(), "/static/css/main.css")
+}
+
+func BenchmarkServeNotFound(b *testing.B) {
+	benchmarkServeHTTP(b, benchMux(), "/users/alacine/nothing/here")
+}
+
+// BenchmarkServeMuxStatic 以 http.ServeMux 作为静态路由的对照
+func BenchmarkServeMuxStatic(b *testing.B) {
+	mux := http.NewServeMux()
+	for _, path := range []string{"/", "/users", "/search/repositories", "/search/code"} {
+		mux.HandleFunc(path, fakeHandlerFunc())
+	}
+	benchmarkServeHTTP(b, mux, "/search/repositories")
+}
+
+func Test_rawRoutePath(t *testing.T) {
+	tests := []struct {
+		escaped, want string
+	}{
+		{"/files/a", "/files/a"},
+		{"/files/a%2fb%2F", "/files/a%2Fb%2F"},
+		{"/files/100%25", "/files/100%25"},
+		{"/book/%E6%88%91", "/book/我"},
+		{"/a%20b", "/a b"},
+	}
+	for _, tt := range tests {
+		if got, err := rawRoutePath(tt.escaped); err != nil || got != tt.want {
+			t.Errorf("rawRoutePath(%q) = %q, %v, want %q", tt.escaped, got, err, tt.want)
+		}
+	}
+	for _, escaped := range []string{"/a%", "/a%2", "/a%zz", "/a%2g"} {
+		if got, err := rawRoutePath(escaped); err == nil {
+			t.Errorf("rawRoutePath(%q) = %q, want error", escaped, got)
+		}
+	}
 }
@@ -1,7 +1,10 @@
 package chu
 
 import (
+	"context"
 	"net/http"
+	"strings"
+	"sync"
 )
 
 // Params URL 中参数的存放方式，key 和 value 序号一一对应
@@ -9,44 +12,213 @@ type Params struct {
 	Keys, Values []string
 }
 
-// Context chu 的 Context，目前只存放 URL 参数
+// Context chu 的 Context，存放 URL 参数和匹配到的路由
+// Context 从 contextPool 中获取，请求处理结束（ServeHTTP 返回）后会被重置并放回 contextPool，
+// 之后随时可能被其他请求取出使用，所以不能在请求结束后继续访问，需要在 handler 返回后读取时使用 Clone 或 Detach
+// 只有路由有 URL 参数、元数据或中间件（包括根路由的中间件），或者请求由 Mount 挂载的 *Mux 处理时，
+// 请求中才有 Context，其他静态路由的 handler 中 URLParam、RouteMeta 等返回零值
 type Context struct {
 
 	// URL path 中的参数
 	URLParams Params
+
+	// 匹配到的路由，找不到路由时为 nil
+	route *Route
+
+	// 请求由 Mount 挂载的 *Mux 处理时，外层路由的路径（去掉 Mount 的通配段）
+	prefix string
+
+	// 根路由有中间件时，ServeHTTP 在路由匹配之前放入 Context，owner 为这个根路由，
+	// 用来和外层 Mux 的 Context 区分，见 Mux.ServeHTTP
+	owner *Mux
 }
 
 // URLParam 从 http.Request 中或取 URL 参数
 func URLParam(r *http.Request, name string) string {
-	if ctx, _ := r.Context().Value(ContextKey).(*Context); ctx != nil {
+	if ctx, _ := r.Context().Value(contextKeyValue).(*Context); ctx != nil {
 		return ctx.URLParam(name)
 	}
 	return ""
 }
 
+// LookupURLParam 和 URLParam 一样获取 URL 参数，参数不存在时 ok 为 false，
+// 可以用来区分可选参数缺失和参数值为空
+func LookupURLParam(r *http.Request, name string) (value string, ok bool) {
+	if ctx, _ := r.Context().Value(contextKeyValue).(*Context); ctx != nil {
+		return ctx.LookupURLParam(name)
+	}
+	return "", false
+}
+
+// RouteMeta 返回请求匹配到的路由的元数据（见 Route.Meta），没有元数据或者没有匹配到路由时返回 nil
+// 根路由的中间件在路由匹配之前执行，需要在调用下一个 handler 之后读取，返回的 map 不能修改
+func RouteMeta(r *http.Request) map[string]interface{} {
+	if ctx, _ := r.Context().Value(contextKeyValue).(*Context); ctx != nil {
+		return ctx.RouteMeta()
+	}
+	return nil
+}
+
+// RoutePattern 返回请求匹配到的路由注册时的完整路径，例如 "/users/:id"，没有匹配到路由时返回空字符串
+// 路径包括子路由的前缀、Host 子路由的 host 和 Mount 的路径，和 Mux.Routes 中的 Pattern 一致，
+// 适合在日志和监控中代替请求路径。根路由的中间件在路由匹配之前执行，需要在调用下一个 handler 之后读取，
+// 外层 Mux 的中间件读取到的是 Mount 挂载的 Mux 中匹配到的完整路径
+func RoutePattern(r *http.Request) string {
+	if ctx, _ := r.Context().Value(contextKeyValue).(*Context); ctx != nil {
+		return ctx.RoutePattern()
+	}
+	return ""
+}
+
 type contextKey string
 
 // ContextKey ...
 var ContextKey = contextKey("ChuContextKey")
 
+// contextKeyValue 转换成 interface{} 的 ContextKey，每次把 ContextKey 转换成 interface{} 都会分配内存，
+// 包内读取和放入 Context 时使用这个值，没有参数的静态路由处理请求时才能不分配内存
+var contextKeyValue interface{} = ContextKey
+
 // NewChuContext return a *Context
 func NewChuContext() *Context {
 	return &Context{}
 }
 
-// Reset ...
+// getContext 从 pool 中获取一个重置过的 Context
+func getContext(pool *sync.Pool) *Context {
+	ctx, _ := pool.Get().(*Context)
+	if ctx == nil {
+		ctx = NewChuContext()
+	}
+	ctx.Reset()
+	return ctx
+}
+
+// putContext 重置 ctx 后放回 pool，ctx 为 nil 时不做处理
+// 重置只是不再引用这个请求的数据，ctx 放回后随时可能被其他请求取出并写入新的参数，
+// 所以 ServeHTTP 返回后还要读取的代码需要先用 Clone 或 Detach 复制，否则不能再访问 ctx
+func putContext(pool *sync.Pool, ctx *Context) {
+	if ctx == nil {
+		return
+	}
+	ctx.Reset()
+	pool.Put(ctx)
+}
+
+// Clone 返回 Context 的副本，副本不会放回 contextPool，请求结束后仍然可以使用
+func (c *Context) Clone() *Context {
+	return &Context{
+		URLParams: Params{
+			Keys:   append([]string(nil), c.URLParams.Keys...),
+			Values: append([]string(nil), c.URLParams.Values...),
+		},
+		route:  c.route,
+		prefix: c.prefix,
+	}
+}
+
+// Detach 返回使用 Context 副本的请求，handler 中启动的 goroutine 在请求结束后
+// 还需要通过 URLParam、RoutePattern 等读取时使用，例如
+//
+//	r = chu.Detach(r)
+//	go func() {
+//		audit(chu.URLParam(r, "id"))
+//	}()
+//
+// 请求中没有 Context 时返回 r
+func Detach(r *http.Request) *http.Request {
+	ctx, _ := r.Context().Value(contextKeyValue).(*Context)
+	if ctx == nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), contextKeyValue, ctx.Clone()))
+}
+
+// Reset 清空 Context 中的参数和路由，保留已经分配的空间
 func (c *Context) Reset() {
 	c.URLParams.Keys = c.URLParams.Keys[:0]
 	c.URLParams.Values = c.URLParams.Values[:0]
+	c.route = nil
+	c.prefix = ""
+	c.owner = nil
 	//c.parentCtx = nil
 }
 
 // URLParam 获取 Context.URLParams 中对应 Param
 func (c *Context) URLParam(name string) string {
+	value, _ := c.LookupURLParam(name)
+	return value
+}
+
+// LookupURLParam 获取 Context.URLParams 中对应 Param，参数不存在时 ok 为 false
+func (c *Context) LookupURLParam(name string) (value string, ok bool) {
 	for i := 0; i < len(c.URLParams.Keys); i++ {
 		if c.URLParams.Keys[i] == name {
-			return c.URLParams.Values[i]
+			return c.URLParams.Values[i], true
 		}
 	}
-	return ""
+	return "", false
+}
+
+// RouteMeta 返回匹配到的路由的元数据，见 Route.Meta
+func (c *Context) RouteMeta() map[string]interface{} {
+	if c.route == nil {
+		return nil
+	}
+	return c.route.attributes().meta
+}
+
+// RoutePattern 返回匹配到的路由的完整路径，见 RoutePattern
+func (c *Context) RoutePattern() string {
+	if c.route == nil {
+		return ""
+	}
+	pattern := c.route.fullPattern
+	if h := c.route.mux.host; h != nil {
+		pattern = h.pattern + pattern
+	}
+	return c.prefix + pattern
+}
+
+// RouteMethod 返回匹配到的路由注册时的 HTTP Method，没有匹配到路由时返回空字符串
+// 没有注册 HEAD 时使用 GET 路由处理的 HEAD 请求（WithAutoHead）返回 GET
+func (c *Context) RouteMethod() string {
+	if c.route == nil {
+		return ""
+	}
+	return c.route.method
+}
+
+// fill 填入路由匹配的结果 matched，matched 的 URL 参数排在 c 中已有的（inheritMount 继承的）参数前面
+// c 和 matched 交换参数的存储空间，matched 之后只能放回 contextPool
+func (c *Context) fill(matched *Context) {
+	matched.URLParams.Keys = append(matched.URLParams.Keys, c.URLParams.Keys...)
+	matched.URLParams.Values = append(matched.URLParams.Values, c.URLParams.Values...)
+	c.URLParams, matched.URLParams = matched.URLParams, c.URLParams
+	c.route = matched.route
+}
+
+// inheritMount parent 匹配到的是 Mount 注册的路由时，继承外层去掉通配段的完整路径和 URL 参数，
+// 外层的参数排在后面，和内层的参数同名时 URLParam 返回内层的参数，没有继承时返回 false
+func (c *Context) inheritMount(parent *Context) bool {
+	pattern := parent.RoutePattern()
+	if !strings.HasSuffix(pattern, "/*"+mountKey) {
+		return false
+	}
+	c.prefix = strings.TrimSuffix(pattern, "/*"+mountKey)
+	for i, key := range parent.URLParams.Keys {
+		if key != mountKey {
+			c.URLParams.Keys = append(c.URLParams.Keys, key)
+			c.URLParams.Values = append(c.URLParams.Values, parent.URLParams.Values[i])
+		}
+	}
+	return true
+}
+
+// copyFrom 把 src 的内容复制到 c 中，不和 src 共享存储空间
+func (c *Context) copyFrom(src *Context) {
+	c.URLParams.Keys = append(c.URLParams.Keys[:0], src.URLParams.Keys...)
+	c.URLParams.Values = append(c.URLParams.Values[:0], src.URLParams.Values...)
+	c.route = src.route
+	c.prefix = src.prefix
 }
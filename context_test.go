@@ -0,0 +1,130 @@
+package chu
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestContextPool(t *testing.T) {
+	mux := New()
+	mux.Get("/users/:id", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, urlParams(r), " ", RoutePattern(r))
+	})
+	mux.Get("/files/:name.:ext", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, urlParams(r), " ", RoutePattern(r))
+	})
+	mux.Get("/static", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, urlParams(r), " ", RoutePattern(r))
+	})
+	mux.Mount("/sub/:tenant", mux)
+
+	tests := []struct{ path, want string }{
+		{"/users/42", "&{{[id] [42]}} /users/:id"},
+		{"/files/a.txt", "&{{[name ext] [a txt]}} /files/:name.:ext"},
+		// 没有参数、元数据和中间件的静态路由不放入 Context，也不会读到其他请求的 Context
+		{"/static", "<nil> "},
+		{"/sub/acme/users/7", "&{{[id tenant] [7 acme]}} /sub/:tenant/users/:id"},
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				tt := tests[(g+i)%len(tests)]
+				rw := httptest.NewRecorder()
+				mux.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, tt.path, nil))
+				if got := rw.Body.String(); got != tt.want {
+					t.Errorf("%s: expect %#v, got %#v", tt.path, tt.want, got)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// 请求结束后 Context 被重置后放回 contextPool，之后可能被其他请求使用，继续持有需要用 Clone 或 Detach
+	var kept *Context
+	mux.Get("/keep/:id", func(rw http.ResponseWriter, r *http.Request) {
+		kept = r.Context().Value(ContextKey).(*Context)
+	})
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/keep/1", nil))
+	if _, ok := kept.LookupURLParam("id"); ok || kept.RoutePattern() != "" {
+		t.Errorf("Context should be reset after ServeHTTP returns, got %v %#v", kept.URLParams, kept.RoutePattern())
+	}
+}
+
+func TestContextPoolReset(t *testing.T) {
+	mux := New()
+	// 模拟 contextPool 中留有之前请求的数据，取出后需要先重置
+	mux.contextPool.New = func() interface{} {
+		return &Context{
+			URLParams: Params{Keys: []string{"id", "stale"}, Values: []string{"0", "1"}},
+			prefix:    "/stale",
+		}
+	}
+	mux.Get("/users/:id", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, urlParams(r), " ", RoutePattern(r))
+	})
+	// 有元数据的静态路由也从 contextPool 中获取 Context
+	mux.Get("/static", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, urlParams(r), " ", RoutePattern(r))
+	}).Meta("cache", "public")
+	for _, tt := range []struct{ path, want string }{
+		{"/users/42", "&{{[id] [42]}} /users/:id"},
+		{"/static", "<nil> /static"},
+	} {
+		rw := httptest.NewRecorder()
+		mux.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if got := rw.Body.String(); got != tt.want {
+			t.Errorf("%s: expect %#v, got %#v", tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestContextClone(t *testing.T) {
+	mux := New()
+	results := make(chan string, 100)
+	var wg sync.WaitGroup
+	mux.Get("/users/:id", func(rw http.ResponseWriter, r *http.Request) {
+		r = Detach(r)
+		ctx := r.Context().Value(ContextKey).(*Context).Clone()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- URLParam(r, "id") + " " + ctx.URLParam("id") + " " + RoutePattern(r)
+		}()
+	})
+
+	for i := 0; i < cap(results); i++ {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, fmt.Sprintf("/users/%d", i), nil))
+	}
+	wg.Wait()
+	close(results)
+	seen := make(map[string]bool)
+	for got := range results {
+		seen[got] = true
+	}
+	for i := 0; i < cap(results); i++ {
+		if want := fmt.Sprintf("%d %d /users/:id", i, i); !seen[want] {
+			t.Errorf("expect %#v from goroutine started by the handler", want)
+		}
+	}
+
+	ctx := &Context{URLParams: Params{Keys: []string{"id"}, Values: []string{"1"}}}
+	clone := ctx.Clone()
+	ctx.Reset()
+	ctx.URLParams.Keys = append(ctx.URLParams.Keys, "name")
+	ctx.URLParams.Values = append(ctx.URLParams.Values, "x")
+	if got := clone.URLParam("id"); got != "1" {
+		t.Errorf("Clone() should not share params with the original Context, got %#v", got)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if Detach(req) != req {
+		t.Errorf("Detach() without Context should return the same request")
+	}
+}
@@ -0,0 +1,42 @@
+package chu
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 注册路由失败时的错误类型，可以用 errors.Is 判断 TryHandle 返回的 error
+var (
+	ErrInvalidPath    = errors.New("chu: invalid path")
+	ErrConflict       = errors.New("chu: conflicting route")
+	ErrDuplicateRoute = errors.New("chu: duplicate route")
+	ErrUnknownMethod  = errors.New("chu: unknown HTTP method")
+)
+
+// RouteError 注册路由失败时返回的错误
+type RouteError struct {
+	Err      error  // ErrInvalidPath、ErrConflict、ErrDuplicateRoute 或 ErrUnknownMethod
+	Method   string // 注册的 HTTP Method
+	Pattern  string // 注册的完整路由路径，包括子路由的前缀
+	Existing string // 冲突或重复的已有路由路径，没有时为空
+	Reason   string // 路径不合法的具体原因，只有 ErrInvalidPath 有
+}
+
+// Error 和以前注册失败时 panic 的信息保持一致
+func (e *RouteError) Error() string {
+	switch e.Err {
+	case ErrInvalidPath:
+		return fmt.Sprintf("Invalid path %q: %s", e.Pattern, e.Reason)
+	case ErrConflict:
+		return "Conflict between " + e.Pattern + " and " + e.Existing
+	case ErrDuplicateRoute:
+		return "Already have handle func for " + e.Pattern + " with " + e.Method
+	case ErrUnknownMethod:
+		return "No such HTTP Method called: " + e.Method
+	}
+	return e.Err.Error()
+}
+
+func (e *RouteError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,66 @@
+package chu
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTryHandle(t *testing.T) {
+	mux := New()
+	for _, path := range []string{"/api/:id/info", "/static/*file", "/book/:id"} {
+		if err := mux.TryHandle(http.MethodGet, path, fakeHandlerFunc()); err != nil {
+			t.Fatalf("TryHandle(%s): %v", path, err)
+		}
+	}
+
+	tests := []struct {
+		method, path string
+		want         error
+		existing     string
+	}{
+		{"GET", "api/:id", ErrInvalidPath, ""},
+		{"GET", "/api//info", ErrInvalidPath, ""},
+		{"GET", "/static/*file/more", ErrInvalidPath, ""},
+		{"GET", "/orders/:id{float}", ErrInvalidPath, ""},
+		{"GETT", "/api/:id", ErrUnknownMethod, ""},
+		{"GET", "/api/:name/detail", ErrConflict, "/api/:id/info"},
+		{"GET", "/static/*path", ErrConflict, "/static/*file"},
+		{"GET", "/book/:id/", ErrDuplicateRoute, "/book/:id"},
+	}
+	for _, tt := range tests {
+		err := mux.TryHandle(tt.method, tt.path, fakeHandlerFunc())
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s %s: expect %v, got %v", tt.method, tt.path, tt.want, err)
+			continue
+		}
+		var re *RouteError
+		if !errors.As(err, &re) {
+			t.Errorf("%s %s: expect *RouteError, got %T", tt.method, tt.path, err)
+			continue
+		}
+		if re.Pattern != tt.path || re.Existing != tt.existing {
+			t.Errorf("%s %s: got pattern %q existing %q, want existing %q",
+				tt.method, tt.path, re.Pattern, re.Existing, tt.existing)
+		}
+	}
+
+	// 注册失败时不会在路由树上留下节点，"/api/:id" 仍然是 404 而不是 405
+	if got := len(mux.Routes()); got != 3 {
+		t.Errorf("expect 3 routes, got %d", got)
+	}
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/api/1/detail", nil))
+	if rw.Code != http.StatusNotFound {
+		t.Errorf("POST /api/1/detail: expect %d, got %d", http.StatusNotFound, rw.Code)
+	}
+
+	// Handle 仍然 panic，panic 的值就是 *RouteError
+	rec := catchPanic(func() {
+		mux.Get("/book/:name", fakeHandlerFunc())
+	})
+	if err, ok := rec.(error); !ok || !errors.Is(err, ErrConflict) {
+		t.Errorf("expect panic with ErrConflict, got %v", rec)
+	}
+}
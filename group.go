@@ -0,0 +1,93 @@
+package chu
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// mountKey Mount 时用来存放剩余路径的参数名
+const mountKey = "chu.mount"
+
+// Route 返回一个带有路由前缀的子路由
+// 子路由和 m 共用同一棵路由树，子路由调用 Use 添加的中间件只对子路由注册的路由生效
+func (m *Mux) Route(prefix string) *Mux {
+	return &Mux{
+		tree:     m.tree,
+		parent:   m,
+		prefix:   m.prefix + strings.TrimRight(prefix, "/"),
+		host:     m.host,
+		matchers: m.matchers,
+	}
+}
+
+// Group 创建一个带有路由前缀的子路由，并在 fn 中注册子路由的路由和中间件
+// 例如
+//
+//	mux.Group("/admin", func(r *chu.Mux) {
+//		r.Use(auth)
+//		r.Get("/users", listUsers)
+//	})
+func (m *Mux) Group(prefix string, fn func(r *Mux)) *Mux {
+	r := m.Route(prefix)
+	if fn != nil {
+		fn(r)
+	}
+	return r
+}
+
+// Mount 把 handler 挂载到 prefix 下，prefix 下的所有请求都交给 handler 处理
+// handler 收到的请求中 URL.Path 已经去掉了 prefix，handler 可以是另一个 *Mux，
+// 这时 prefix 中的参数（例如 "/t/:tenant" 中的 tenant）在 handler 中同样可以通过 URLParam 获取
+func (m *Mux) Mount(prefix string, handler http.Handler) {
+	pattern := strings.TrimRight(prefix, "/") + "/*" + mountKey
+	mounted := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		rest := URLParam(r, mountKey)
+		r2.URL.Path = "/" + rest
+		r2.URL.RawPath = ""
+		if raw := escapedSuffix(r.URL.EscapedPath(), rest); raw != "" {
+			// 保留编码，handler 是开启 WithRawPath 的 *Mux 时 "%2F" 仍然不是分隔符
+			r2.URL.RawPath = "/" + raw
+		}
+		handler.ServeHTTP(w, r2)
+	})
+	m.Any(pattern, mounted)
+}
+
+// escapedSuffix 返回 escaped 中 '/' 之后还原转义后等于 rest 的部分，即 rest 编码后的原样，
+// 找不到或者和 rest 相同（不需要 RawPath）时返回空字符串
+func escapedSuffix(escaped, rest string) string {
+	for i := len(escaped) - 1; i >= 0; i-- {
+		if escaped[i] != '/' {
+			continue
+		}
+		raw := escaped[i+1:]
+		if len(raw) < len(rest) {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(raw); err == nil && unescaped == rest {
+			if raw == rest {
+				return ""
+			}
+			return raw
+		}
+		if len(raw) > 3*len(rest) {
+			break
+		}
+	}
+	return ""
+}
+
+// With 返回一个带有额外中间件的子路由，不会修改 m 的中间件
+// 适合只给个别路由添加中间件，例如
+//
+//	mux.With(middleware.Timeout(time.Second)).Get("/report", report)
+func (m *Mux) With(middlewares ...Middleware) *Mux {
+	r := m.Route("")
+	r.Use(middlewares...)
+	return r
+}
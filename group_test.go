@@ -0,0 +1,136 @@
+package chu
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func headerMiddleware(key, value string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			rw.Header().Add(key, value)
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+func TestGroup(t *testing.T) {
+	mux := New()
+	mux.Use(headerMiddleware("X-Global", "1"))
+	mux.Get("/ping", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "pong")
+	})
+	mux.Group("/admin", func(r *Mux) {
+		r.Use(headerMiddleware("X-Admin", "1"))
+		r.Get("/users/:id", func(rw http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(rw, "admin user ", URLParam(r, "id"))
+		})
+		r.Route("/settings").Get("/", func(rw http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(rw, "admin settings")
+		})
+	})
+
+	tests := []struct {
+		path, body    string
+		global, admin string
+	}{
+		{"/ping", "pong", "1", ""},
+		{"/admin/users/42", "admin user 42", "1", "1"},
+		{"/admin/settings", "admin settings", "1", "1"},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, tt.path, nil)
+		mux.ServeHTTP(rw, req)
+		if got := rw.Body.String(); got != tt.body {
+			t.Errorf("%s: expect %#v, got %#v", tt.path, tt.body, got)
+		}
+		if got := rw.Header().Get("X-Global"); got != tt.global {
+			t.Errorf("%s: expect X-Global %#v, got %#v", tt.path, tt.global, got)
+		}
+		if got := rw.Header().Get("X-Admin"); got != tt.admin {
+			t.Errorf("%s: expect X-Admin %#v, got %#v", tt.path, tt.admin, got)
+		}
+	}
+}
+
+func TestMount(t *testing.T) {
+	sub := New()
+	sub.Use(headerMiddleware("X-Sub", "1"))
+	sub.Get("/", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "index ", r.URL.Path)
+	})
+	sub.Get("/items/:id", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "item ", URLParam(r, "id"), " ", r.URL.Path)
+	})
+	sub.Get("/whoami", func(rw http.ResponseWriter, r *http.Request) {
+		_, ok := LookupURLParam(r, mountKey)
+		fmt.Fprint(rw, "tenant ", URLParam(r, "tenant"), " ", ok)
+	})
+
+	mux := New()
+	mux.Use(headerMiddleware("X-Global", "1"))
+	mux.Mount("/shop", sub)
+	mux.Mount("/t/:tenant", sub)
+	mux.Mount("/files/", http.NotFoundHandler())
+
+	tests := []struct {
+		method, path, body string
+		code               int
+	}{
+		{http.MethodGet, "/shop", "index /", http.StatusOK},
+		{http.MethodGet, "/shop/", "index /", http.StatusOK},
+		{http.MethodGet, "/shop/items/7", "item 7 /items/7", http.StatusOK},
+		{http.MethodPost, "/shop/items/7", "Method Not Allowed\n", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/shop/nothing/here", "404 page not found\n", http.StatusNotFound},
+		{http.MethodGet, "/files/a/b", "404 page not found\n", http.StatusNotFound},
+		// Mount 路径中的参数在挂载的 Mux 中也可以获取，Mount 的通配参数除外
+		{http.MethodGet, "/t/acme/items/7", "item 7 /items/7", http.StatusOK},
+		{http.MethodGet, "/t/acme/whoami", "tenant acme false", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest(tt.method, tt.path, nil)
+		mux.ServeHTTP(rw, req)
+		if code := rw.Result().StatusCode; code != tt.code {
+			t.Errorf("%s %s: expect %v status code, got %v", tt.method, tt.path, tt.code, code)
+		}
+		if got := rw.Body.String(); got != tt.body {
+			t.Errorf("%s %s: expect %#v, got %#v", tt.method, tt.path, tt.body, got)
+		}
+		if got := rw.Header().Get("X-Global"); got != "1" {
+			t.Errorf("%s %s: expect X-Global header from the parent mux", tt.method, tt.path)
+		}
+	}
+}
+
+func TestWith(t *testing.T) {
+	mux := New()
+	mux.Use(headerMiddleware("X-Order", "global"))
+	hello := func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "hello")
+	}
+	mux.With(headerMiddleware("X-Order", "with")).Get("/slow", hello)
+	mux.Get("/fast", hello)
+
+	tests := []struct {
+		path  string
+		order []string
+	}{
+		{"/slow", []string{"global", "with"}},
+		{"/fast", []string{"global"}},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, tt.path, nil)
+		mux.ServeHTTP(rw, req)
+		if got := rw.Header().Values("X-Order"); fmt.Sprint(got) != fmt.Sprint(tt.order) {
+			t.Errorf("%s: expect middlewares %v, got %v", tt.path, tt.order, got)
+		}
+	}
+	if n := len(mux.middlewares); n != 1 {
+		t.Errorf("With should not change the middlewares of mux, got %v middlewares", n)
+	}
+}
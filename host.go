@@ -0,0 +1,200 @@
+package chu
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// hostRouter Host 创建的子路由，有单独的路由表
+// 处理请求时先用 r.Host 匹配 host，再在这个路由表中匹配路径
+type hostRouter struct {
+	pattern string
+	mux     *Mux // 在 host 的路由表中匹配路由时使用，不用来注册路由
+
+	re     *regexp.Regexp
+	keys   []string // host 中的参数名
+	groups []int    // 参数在 re 中的分组序号，和 keys 一一对应
+
+	// 当前的路由表（*table），和 tree.current 一样原子地读取和替换
+	current atomic.Value
+}
+
+// Host 返回只处理 r.Host 匹配 pattern 的请求的子路由，例如
+//
+//	api := mux.Host("api.example.com")
+//	api.Get("/users", listUsers)
+//	tenant := mux.Host(":tenant.example.com")
+//	tenant.Get("/", home) // chu.URLParam(r, "tenant")
+//
+// pattern 以 '.' 分隔，每一段和路径中的段一样可以是静态文本、参数或带约束的参数，
+// 参数值和路径参数一样通过 URLParam 获取，排在路径参数前面
+// 匹配时忽略 r.Host 中的端口和大小写，多个 Host 子路由按照创建的顺序匹配，
+// host 匹配但找不到路由时继续尝试后面的 Host 子路由，最后使用没有 host 限制的路由
+// 同一个 pattern 共享同一个路由表，但每次调用都返回新的子路由，和 Route 一样路径前缀、
+// 中间件和匹配条件继承自 m，例如 mux.Route("/v1").Host("api.example.com").Get("/x", h) 注册的是 "/v1/x"
+func (m *Mux) Host(pattern string) *Mux {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.hostRouter(pattern)
+	return &Mux{
+		tree:     m.tree,
+		parent:   m,
+		prefix:   m.prefix,
+		host:     h,
+		matchers: m.matchers,
+	}
+}
+
+// hostRouter 返回 pattern 对应的 Host 子路由，还没有时创建一个，调用时需要持有 m.mu
+func (m *Mux) hostRouter(pattern string) *hostRouter {
+	hosts := m.hostRouters()
+	for _, h := range hosts {
+		if h.pattern == pattern {
+			return h
+		}
+	}
+
+	h, err := parseHost(pattern)
+	if err != nil {
+		panic(&RouteError{Err: ErrInvalidPath, Pattern: pattern, Reason: err.Error()})
+	}
+	base := m.tree.load()
+	h.current.Store(&table{
+		nodes:       make([]*node, 0, defaultCapacity),
+		pool:        base.pool,
+		methods:     base.methods,
+		strictSlash: base.strictSlash,
+		ignoreCase:  base.ignoreCase,
+	})
+	h.mux = &Mux{tree: m.tree, host: h}
+	m.hosts.Store(append(hosts[:len(hosts):len(hosts)], h))
+	return h
+}
+
+// hostRouters 返回所有 Host 子路由
+func (t *tree) hostRouters() []*hostRouter {
+	hosts, _ := t.hosts.Load().([]*hostRouter)
+	return hosts
+}
+
+// load 返回 Host 子路由当前的路由表
+func (h *hostRouter) load() *table {
+	return h.current.Load().(*table)
+}
+
+// parseHost 把 host pattern 转换成正则表达式
+func parseHost(pattern string) (*hostRouter, error) {
+	h := &hostRouter{pattern: pattern}
+	labels := strings.Split(pattern, ".")
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i, label := range labels {
+		if len(label) == 0 {
+			return nil, errors.New("empty label")
+		}
+		if i > 0 {
+			sb.WriteString(`\.`)
+		}
+		if isCatchAll(label) {
+			return nil, errors.New("catch-all label " + label + " is not supported")
+		}
+		parts, err := splitSegment(label)
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range parts {
+			if !isWildcard(part) {
+				sb.WriteString(regexp.QuoteMeta(strings.ToLower(part)))
+				continue
+			}
+			key, expr, _ := paramExpr(part)
+			if len(expr) == 0 {
+				// 和路径参数一样，参数值尽量短
+				expr = `[^.]+?`
+			}
+			name := "chuhost" + strconv.Itoa(len(h.keys))
+			sb.WriteString("(?P<" + name + ">" + expr + ")")
+			h.keys = append(h.keys, key)
+		}
+	}
+	sb.WriteString("$")
+
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil, err
+	}
+	h.re = re
+	for i := range h.keys {
+		h.groups = append(h.groups, re.SubexpIndex("chuhost"+strconv.Itoa(i)))
+	}
+	return h, nil
+}
+
+// match 判断 host 是否匹配，返回 host 中的参数值
+func (h *hostRouter) match(host string) ([]string, bool) {
+	if len(h.keys) == 0 {
+		return nil, h.re.MatchString(host)
+	}
+	sub := h.re.FindStringSubmatch(host)
+	if sub == nil {
+		return nil, false
+	}
+	values := make([]string, len(h.groups))
+	for i, g := range h.groups {
+		values[i] = sub[g]
+	}
+	return values, true
+}
+
+// requestHost 去掉 r.Host 中的端口并转换成小写
+func requestHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// addHostParams 把 host 中的参数放到 ctx 中路径参数的前面，ctx 为 nil 时从 contextPool 中获取
+func (m *Mux) addHostParams(ctx *Context, keys, values []string) *Context {
+	if ctx == nil {
+		ctx = getContext(m.contextPool)
+	}
+	ps := &ctx.URLParams
+	n, cnt := len(keys), len(ps.Keys)
+	ps.Keys = append(ps.Keys, keys...)
+	ps.Values = append(ps.Values, values...)
+	copy(ps.Keys[n:], ps.Keys[:cnt])
+	copy(ps.Values[n:], ps.Values[:cnt])
+	copy(ps.Keys, keys)
+	copy(ps.Values, values)
+	return ctx
+}
+
+// selectHandler 按照顺序匹配 Host 子路由，都找不到路由时使用没有 host 限制的路由，
+// 返回处理请求的路由和 getHandler 的结果，Context 中包括 host 中的参数
+func (m *Mux) selectHandler(r *http.Request, method, path string) (*Mux, http.Handler, *Context, methodType, errCode) {
+	if hosts := m.hostRouters(); len(hosts) > 0 {
+		host := requestHost(r.Host)
+		for _, h := range hosts {
+			values, ok := h.match(host)
+			if !ok {
+				continue
+			}
+			handle, ctx, allow, code := h.mux.getHandler(r, method, path)
+			if code != NotFound {
+				if len(h.keys) > 0 {
+					ctx = m.addHostParams(ctx, h.keys, values)
+				}
+				return h.mux, handle, ctx, allow, code
+			}
+			putContext(m.contextPool, ctx)
+		}
+	}
+	handle, ctx, allow, code := m.getHandler(r, method, path)
+	return m, handle, ctx, allow, code
+}
@@ -0,0 +1,121 @@
+package chu
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHost(t *testing.T) {
+	handle := func(name string) http.HandlerFunc {
+		return func(rw http.ResponseWriter, r *http.Request) {
+			namedHandler(name)(rw, r)
+			fmt.Fprint(rw, " ", rw.Header().Get("X-Host"))
+		}
+	}
+	mux := New()
+	mux.Get("/users", handle("default"))
+	mux.Get("/users/:id", handle("default user"))
+
+	api := mux.Host("api.example.com")
+	api.Use(headerMiddleware("X-Host", "api"))
+	api.Get("/users", handle("api"))
+	api.Route("/v1").Get("/users/:id", handle("api user"))
+
+	tenant := mux.Host(":tenant.example.com")
+	tenant.Get("/projects/:id", handle("project"))
+	mux.Host(":app-:env{alpha}.apps.example.com").Get("/", handle("app"))
+
+	tests := []struct {
+		host, path, want string
+	}{
+		{"api.example.com", "/users", "api <nil> api"},
+		{"API.Example.com:8080", "/users", "api <nil> api"},
+		{"api.example.com", "/v1/users/42", "api user &{{[id] [42]}} api"},
+		{"acme.example.com", "/projects/7", "project &{{[tenant id] [acme 7]}} "},
+		{"Acme.Example.com", "/projects/7", "project &{{[tenant id] [acme 7]}} "},
+		{"shop-a-prod.apps.example.com", "/", "app &{{[app env] [shop-a prod]}} "},
+		// host 匹配但找不到路由时使用没有 host 限制的路由
+		{"api.example.com", "/users/42", "default user &{{[id] [42]}} "},
+		{"acme.example.com", "/users", "default <nil> "},
+		{"example.com", "/users", "default <nil> "},
+		{"a.b.example.com", "/users", "default <nil> "},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host, req.URL.Path = tt.host, tt.path
+		mux.ServeHTTP(rw, req)
+		if got := rw.Body.String(); got != tt.want {
+			t.Errorf("%s%s: expect %#v, got %#v", tt.host, tt.path, tt.want, got)
+		}
+	}
+
+	for _, tt := range []struct{ host, path string }{
+		{"shop-1.apps.example.com", "/"},
+		{"example.com", "/projects/7"},
+	} {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host, req.URL.Path = tt.host, tt.path
+		mux.ServeHTTP(rw, req)
+		if rw.Code != http.StatusNotFound {
+			t.Errorf("%s%s: expect 404 status code, got %v", tt.host, tt.path, rw.Code)
+		}
+	}
+
+	want := []string{
+		"GET /users",
+		"GET /users/:id",
+		"GET api.example.com/users",
+		"GET api.example.com/v1/users/:id",
+		"GET :tenant.example.com/projects/:id",
+		"GET :app-:env{alpha}.apps.example.com/",
+	}
+	var got []string
+	mux.Walk(func(method, pattern string, h http.Handler) error {
+		got = append(got, method+" "+pattern)
+		return nil
+	})
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Walk() = %v, want %v", got, want)
+	}
+
+	// 同一个 pattern 共享路由表，但路径前缀和中间件来自调用的子路由
+	v1 := mux.Route("/v1")
+	v1.Use(headerMiddleware("X-Host", "v1"))
+	v1.Host("api.example.com").Get("/status", handle("status")).Name("status")
+	mux.Host("api.example.com").Get("/health", handle("health"))
+	for _, tt := range []struct{ path, want string }{
+		{"/v1/status", "status <nil> v1"},
+		{"/health", "health <nil> "},
+		{"/users", "api <nil> api"},
+	} {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Host = "api.example.com"
+		mux.ServeHTTP(rw, req)
+		if got := rw.Body.String(); got != tt.want {
+			t.Errorf("api.example.com%s: expect %#v, got %#v", tt.path, tt.want, got)
+		}
+	}
+
+	// Host 子路由中命名的路由也可以通过根路由生成路径，名字不能和其他路由重复
+	if url, err := mux.URL("status"); err != nil || url != "/v1/status" {
+		t.Errorf("URL(status) = %#v, %v, want /v1/status", url, err)
+	}
+	if rec := catchPanic(func() { mux.Get("/status", handle("status")).Name("status") }); rec == nil {
+		t.Errorf("Name() with a name used by a host route should panic")
+	}
+
+	for _, pattern := range []string{"", "api..example.com", "*.example.com", ":a:b.example.com", ":id{float}.example.com"} {
+		rec := catchPanic(func() {
+			mux.Host(pattern)
+		})
+		if err, ok := rec.(error); !ok || !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("Host(%q): expect panic with ErrInvalidPath, got %v", pattern, rec)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package chu
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Matcher 路由的匹配条件，由 Header、Query、ContentType 和 MatchFunc 创建，通过 Mux.When 使用
+type Matcher struct {
+	match func(r *http.Request) bool
+
+	// 同一路径、同一方法上的所有路由都不满足条件时返回的状态码
+	status int
+}
+
+// Header 请求头 key 的值等于 value 时匹配，value 为空时只要求请求头存在
+// key 以 "Accept" 开头时（例如 Accept-Version），不满足条件返回 406，否则返回 404
+func Header(key, value string) Matcher {
+	key = http.CanonicalHeaderKey(key)
+	status := http.StatusNotFound
+	if strings.HasPrefix(key, "Accept") {
+		status = http.StatusNotAcceptable
+	}
+	return Matcher{
+		match: func(r *http.Request) bool {
+			values, ok := r.Header[key]
+			if !ok {
+				return false
+			}
+			if value == "" {
+				return true
+			}
+			for _, v := range values {
+				if v == value {
+					return true
+				}
+			}
+			return false
+		},
+		status: status,
+	}
+}
+
+// Query URL 中的查询参数 key 的值等于 value 时匹配，value 为空时只要求参数存在，不满足条件返回 404
+func Query(key, value string) Matcher {
+	return Matcher{
+		match: func(r *http.Request) bool {
+			values, ok := r.URL.Query()[key]
+			if !ok {
+				return false
+			}
+			if value == "" {
+				return true
+			}
+			for _, v := range values {
+				if v == value {
+					return true
+				}
+			}
+			return false
+		},
+		status: http.StatusNotFound,
+	}
+}
+
+// ContentType 请求的 Content-Type 是 types 中的一种时匹配，忽略大小写和 charset 等参数，
+// 例如 ContentType("application/json", "multipart/form-data")，不满足条件返回 415
+func ContentType(types ...string) Matcher {
+	return Matcher{
+		match: func(r *http.Request) bool {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil {
+				return false
+			}
+			for _, t := range types {
+				if strings.EqualFold(mediaType, t) {
+					return true
+				}
+			}
+			return false
+		},
+		status: http.StatusUnsupportedMediaType,
+	}
+}
+
+// MatchFunc 使用自定义的函数判断是否匹配，不满足条件返回 404
+func MatchFunc(fn func(r *http.Request) bool) Matcher {
+	return Matcher{match: fn, status: http.StatusNotFound}
+}
+
+// When 返回一个带有匹配条件的子路由，通过子路由注册的路由只处理满足所有条件的请求，例如
+//
+//	mux.When(chu.Header("Accept-Version", "2")).Get("/items", listItemsV2)
+//	mux.Get("/items", listItems)
+//
+// 同一路径、同一方法上可以注册多个带条件的路由，按照注册顺序选择第一个满足所有条件的路由，
+// 没有条件的路由最后尝试。都不满足时，如果每个路由第一个不满足的条件的状态码都相同（例如都是
+// ContentType），返回这个状态码，否则返回 404
+func (m *Mux) When(matchers ...Matcher) *Mux {
+	r := m.Route("")
+	r.matchers = append(m.matchers[:len(m.matchers):len(m.matchers)], matchers...)
+	return r
+}
+
+// selectRoute 按照顺序选择第一个满足所有条件的路由，都不满足时返回 nil 和应该返回的状态码
+func selectRoute(r *http.Request, routes []*Route) (*Route, int) {
+	status := 0
+	for _, rt := range routes {
+		failed := rt.failedMatcher(r)
+		if failed == nil {
+			return rt, 0
+		}
+		switch status {
+		case 0:
+			status = failed.status
+		case failed.status:
+		default:
+			status = http.StatusNotFound
+		}
+	}
+	return nil, status
+}
+
+// failedMatcher 返回第一个不满足的条件，都满足时返回 nil
+func (rt *Route) failedMatcher(r *http.Request) *Matcher {
+	for i := range rt.matchers {
+		if !rt.matchers[i].match(r) {
+			return &rt.matchers[i]
+		}
+	}
+	return nil
+}
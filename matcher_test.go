@@ -0,0 +1,98 @@
+package chu
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWhen(t *testing.T) {
+	mux := New(WithAutoHead())
+	// 没有条件的路由先注册，也要排在带条件的路由后面
+	mux.Get("/items", namedHandler("v1"))
+	mux.When(Header("Accept-Version", "2")).Get("/items", namedHandler("v2"))
+	mux.When(Query("format", "csv")).Get("/items", namedHandler("csv"))
+
+	upload := mux.Route("/upload")
+	upload.When(ContentType("application/json")).Post("", namedHandler("json"))
+	upload.When(ContentType("multipart/form-data")).Post("", namedHandler("multipart"))
+
+	mux.When(Header("Accept-Version", "2")).Get("/reports/:id", namedHandler("report v2"))
+	mux.When(Header("X-Debug", ""), MatchFunc(func(r *http.Request) bool {
+		return r.URL.Query().Get("trace") == "1"
+	})).Get("/debug", namedHandler("debug"))
+
+	tests := []struct {
+		method, path string
+		header       http.Header
+		code         int
+		want         string
+	}{
+		{http.MethodGet, "/items", nil, http.StatusOK, "v1 <nil>"},
+		{http.MethodGet, "/items", http.Header{"Accept-Version": {"2"}}, http.StatusOK, "v2 <nil>"},
+		{http.MethodGet, "/items", http.Header{"Accept-Version": {"3"}}, http.StatusOK, "v1 <nil>"},
+		{http.MethodGet, "/items?format=csv", nil, http.StatusOK, "csv <nil>"},
+		{http.MethodGet, "/items?format=csv", http.Header{"Accept-Version": {"2"}}, http.StatusOK, "v2 <nil>"},
+		{http.MethodHead, "/items", http.Header{"Accept-Version": {"2"}}, http.StatusOK, ""},
+		{http.MethodPost, "/upload", http.Header{"Content-Type": {"application/json; charset=utf-8"}}, http.StatusOK, "json <nil>"},
+		{http.MethodPost, "/upload", http.Header{"Content-Type": {"Multipart/Form-Data; boundary=x"}}, http.StatusOK, "multipart <nil>"},
+		{http.MethodPost, "/upload", http.Header{"Content-Type": {"text/plain"}}, http.StatusUnsupportedMediaType, ""},
+		{http.MethodPost, "/upload", nil, http.StatusUnsupportedMediaType, ""},
+		{http.MethodGet, "/upload", nil, http.StatusMethodNotAllowed, ""},
+		{http.MethodGet, "/reports/1", http.Header{"Accept-Version": {"2"}}, http.StatusOK, "report v2 &{{[id] [1]}}"},
+		{http.MethodGet, "/reports/1", nil, http.StatusNotAcceptable, ""},
+		{http.MethodHead, "/reports/1", nil, http.StatusNotAcceptable, ""},
+		{http.MethodGet, "/debug?trace=1", http.Header{"X-Debug": {""}}, http.StatusOK, "debug <nil>"},
+		{http.MethodGet, "/debug?trace=1", nil, http.StatusNotFound, ""},
+		{http.MethodGet, "/debug", http.Header{"X-Debug": {"on"}}, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		for k, v := range tt.header {
+			req.Header[k] = v
+		}
+		mux.ServeHTTP(rw, req)
+		if rw.Code != tt.code {
+			t.Errorf("%s %s %v: expect %v status code, got %v", tt.method, tt.path, tt.header, tt.code, rw.Code)
+		}
+		if tt.code == http.StatusOK && rw.Body.String() != tt.want {
+			t.Errorf("%s %s %v: expect %#v, got %#v", tt.method, tt.path, tt.header, tt.want, rw.Body.String())
+		}
+	}
+
+	// 带条件的路由不会重复，没有条件的路由只能有一个
+	mux.When(Header("Accept-Version", "2")).Get("/items", namedHandler("v2 again"))
+	if err := mux.TryHandle(http.MethodGet, "/items", namedHandler("v1 again")); !errors.Is(err, ErrDuplicateRoute) {
+		t.Errorf("TryHandle() = %v, want ErrDuplicateRoute", err)
+	}
+
+	want := []string{
+		"GET /items", "GET /items", "GET /items", "GET /items",
+		"POST /upload", "POST /upload",
+		"GET /reports/:id",
+		"GET /debug",
+	}
+	var got []string
+	mux.Walk(func(method, pattern string, h http.Handler) error {
+		got = append(got, method+" "+pattern)
+		return nil
+	})
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Walk() = %v, want %v", got, want)
+	}
+
+	// Remove 删除同一路径、同一方法上的所有路由
+	if err := mux.Remove(http.MethodPost, "/upload"); err != nil {
+		t.Errorf("Remove() = %v", err)
+	}
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/upload", nil)
+	req.Header.Set("Content-Type", "application/json")
+	mux.ServeHTTP(rw, req)
+	if rw.Code != http.StatusNotFound {
+		t.Errorf("POST /upload after Remove: expect 404 status code, got %v", rw.Code)
+	}
+}
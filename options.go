@@ -0,0 +1,123 @@
+package chu
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Option New 的可选配置
+type Option func(*Mux)
+
+// WithAutoOptions 自动回应没有注册的 OPTIONS 请求，响应中带有路径允许的方法（Allow 头）
+// handler 为 nil 时返回 204，否则交给 handler 处理，调用 handler 前 Allow 头已经设置好，
+// 可以在 handler 中处理 CORS 预检请求
+func WithAutoOptions(handler http.Handler) Option {
+	return func(m *Mux) {
+		m.autoOptions = true
+		m.optionsHandler = handler
+	}
+}
+
+// WithAutoHead 没有注册 HEAD 的路径使用 GET 的 handler 处理 HEAD 请求，并丢弃响应体
+func WithAutoHead() Option {
+	return func(m *Mux) {
+		m.autoHead = true
+	}
+}
+
+// WithCapacity 根据预计的路由数量 n 为路由树预先分配空间，避免注册路由时多次扩容
+// 路由树会按需增长，n 只影响初始容量
+func WithCapacity(n int) Option {
+	return func(m *Mux) {
+		tb := m.load()
+		if n <= 0 || len(tb.nodes) != 0 {
+			return
+		}
+		// 每个路由平均新增的节点数量按 2 估算
+		tb.nodes = make([]*node, 0, 2*n)
+	}
+}
+
+// WithHotSwap 开启路由表热替换
+// 开启后已经生效的路由表不会再被修改，注册和删除路由（Handle、Remove 等）时复制一份新的路由表，
+// 修改完成后原子地替换，所以可以在处理请求的同时注册和删除路由
+// 每次修改都会复制节点列表，大量注册路由时比默认模式慢
+func WithHotSwap() Option {
+	return func(m *Mux) {
+		m.hotSwap = true
+	}
+}
+
+// WithStrictSlash 路径末尾的 '/' 有区别，"/users/" 只能匹配注册为 "/users/" 的路由，
+// 默认情况下会忽略路径末尾的 '/'，"/users/" 和 "/users" 匹配同一个路由
+// 通配段 "*xxx" 的参数值包括请求路径末尾的 '/'
+func WithStrictSlash() Option {
+	return func(m *Mux) {
+		m.load().strictSlash = true
+	}
+}
+
+// WithRedirectSlash 开启 WithStrictSlash，请求路径匹配不到注册了请求方法的路由，但加上或去掉末尾的 '/' 后可以匹配时，
+// 重定向到加上或去掉 '/' 后的路径，code 通常为 http.StatusMovedPermanently，
+// 需要保留请求方法和 body 时使用 http.StatusPermanentRedirect
+func WithRedirectSlash(code int) Option {
+	return func(m *Mux) {
+		m.load().strictSlash = true
+		m.redirectSlash = code
+	}
+}
+
+// WithCleanPath 和 http.ServeMux 一样，请求路径中有 "//"、"." 或 ".." 时，
+// 重定向到 path.Clean 规范后的路径（保留末尾的 '/'），code 的用法和 WithRedirectSlash 相同
+// CONNECT 请求不做处理。同时开启 WithRawPath 时在编码后的路径上规范，"%2F" 不算分隔符
+func WithCleanPath(code int) Option {
+	return func(m *Mux) {
+		m.cleanPath = code
+	}
+}
+
+// WithRawPath 使用编码后的请求路径（URL.EscapedPath）匹配路由，
+// 参数中编码的 '/'（"%2F"）不会被当作分隔符，例如 "/files/a%2Fb" 匹配 "/files/:name" 时 name 为 "a/b"
+// 其他转义字符在匹配前就会还原，所以静态文本和参数约束不受编码方式影响，
+// 参数值在匹配之后单独解码，参数约束检查的是解码前的值（只保留 "%2F" 和 "%25"）
+func WithRawPath() Option {
+	return func(m *Mux) {
+		m.rawPath = true
+	}
+}
+
+// WithIgnoreCase 匹配路由时静态文本忽略 ASCII 字母的大小写，"/Users/42" 可以匹配 "/users/:id"，
+// 参数值保持请求中的原样。大小写完全一致的静态路由优先匹配，其他忽略大小写后相同的静态路由按照注册的顺序
+func WithIgnoreCase() Option {
+	return func(m *Mux) {
+		m.load().ignoreCase = true
+	}
+}
+
+// WithCaseRedirect 开启 WithIgnoreCase，请求路径的大小写和匹配到的路由不一致时，
+// 重定向到使用注册时大小写的路径，code 的用法和 WithRedirectSlash 相同
+func WithCaseRedirect(code int) Option {
+	return func(m *Mux) {
+		m.load().ignoreCase = true
+		m.caseRedirect = code
+	}
+}
+
+// WithMethods 添加可以注册的扩展 HTTP Method，例如 WebDAV 的 PROPFIND、MKCOL，
+// 添加后和内置的 Method 一样通过 Handle、Match 注册，Any 也会包括这些 Method
+// 没有添加的 Method 注册时返回 ErrUnknownMethod，避免拼写错误。method 不是合法的 token 时 panic
+func WithMethods(methods ...string) Option {
+	return func(m *Mux) {
+		for _, method := range methods {
+			if !validMethod(method) {
+				panic(&RouteError{Err: ErrUnknownMethod, Method: method})
+			}
+		}
+		tb := m.load()
+		set, ok := tb.methods.with(methods...)
+		if !ok {
+			panic("Too many HTTP methods, at most " + strconv.Itoa(maxMethods))
+		}
+		tb.methods = set
+	}
+}
@@ -0,0 +1,524 @@
+package chu
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAutoOptionsAndHead(t *testing.T) {
+	hello := func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("X-Hello", "1")
+		fmt.Fprint(rw, "hello")
+	}
+	preflight := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Access-Control-Allow-Methods", rw.Header().Get("Allow"))
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name         string
+		opts         []Option
+		method, path string
+		code         int
+		allow, body  string
+	}{
+		{"disabled", nil, http.MethodOptions, "/hello", http.StatusMethodNotAllowed, "GET, POST", "Method Not Allowed\n"},
+		{"disabled", nil, http.MethodHead, "/hello", http.StatusMethodNotAllowed, "GET, POST", "Method Not Allowed\n"},
+		{"options", []Option{WithAutoOptions(nil)}, http.MethodOptions, "/hello", http.StatusNoContent, "GET, POST, OPTIONS", ""},
+		{"options", []Option{WithAutoOptions(preflight)}, http.MethodOptions, "/hello", http.StatusOK, "GET, POST, OPTIONS", ""},
+		{"options", []Option{WithAutoOptions(nil)}, http.MethodOptions, "/nothing", http.StatusNotFound, "", "404 page not found\n"},
+		{"head", []Option{WithAutoHead()}, http.MethodHead, "/hello", http.StatusOK, "", ""},
+		{"head", []Option{WithAutoHead()}, http.MethodHead, "/post", http.StatusMethodNotAllowed, "POST", "Method Not Allowed\n"},
+		{"both", []Option{WithAutoHead(), WithAutoOptions(nil)}, http.MethodPut, "/hello", http.StatusMethodNotAllowed, "GET, POST, HEAD, OPTIONS", "Method Not Allowed\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := New(tt.opts...)
+			mux.Get("/hello", hello)
+			mux.Post("/hello", hello)
+			mux.Post("/post", hello)
+
+			rw := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			mux.ServeHTTP(rw, req)
+			if code := rw.Result().StatusCode; code != tt.code {
+				t.Errorf("%s %s: expect %v status code, got %v", tt.method, tt.path, tt.code, code)
+			}
+			if got := rw.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("%s %s: expect Allow header %#v, got %#v", tt.method, tt.path, tt.allow, got)
+			}
+			if got := rw.Body.String(); got != tt.body {
+				t.Errorf("%s %s: expect %#v, got %#v", tt.method, tt.path, tt.body, got)
+			}
+			if tt.method == http.MethodHead && tt.code == http.StatusOK && rw.Header().Get("X-Hello") != "1" {
+				t.Errorf("HEAD should be handled by the GET handler")
+			}
+			if acam := rw.Header().Get("Access-Control-Allow-Methods"); acam != "" && acam != tt.allow {
+				t.Errorf("expect preflight handler to see Allow header %#v, got %#v", tt.allow, acam)
+			}
+		})
+	}
+}
+
+func TestHotSwap(t *testing.T) {
+	mux := New(WithHotSwap())
+	mux.Get("/stable/:id", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, URLParam(r, "id"))
+	})
+
+	done := make(chan struct{})
+	errs := make(chan error, 4)
+	var wg, started sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		started.Add(1)
+		go func() {
+			var once sync.Once
+			defer wg.Done()
+			defer once.Do(started.Done)
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				rw := httptest.NewRecorder()
+				req, _ := http.NewRequest(http.MethodGet, "/stable/42", nil)
+				mux.ServeHTTP(rw, req)
+				if rw.Code != http.StatusOK || rw.Body.String() != "42" {
+					errs <- fmt.Errorf("got %v %#v while routes are changing", rw.Code, rw.Body.String())
+					return
+				}
+				req, _ = http.NewRequest(http.MethodGet, "/dynamic/1/items", nil)
+				mux.ServeHTTP(httptest.NewRecorder(), req)
+				mux.Routes()
+				once.Do(started.Done)
+			}
+		}()
+	}
+	started.Wait()
+
+	for i := 0; i < 200; i++ {
+		path := fmt.Sprintf("/dynamic/%d/items", i%10)
+		if i%20 < 10 {
+			mux.Get(path, fakeHandlerFunc()).Name(path).Meta("index", i)
+		} else if err := mux.Remove(http.MethodGet, path); err != nil {
+			t.Errorf("Remove(%s) = %v", path, err)
+		}
+	}
+	close(done)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithHotSwap()}} {
+		mux := New(opts...)
+		mux.Get("/users", fakeHandlerFunc())
+		mux.Get("/users/:id", fakeHandlerFunc()).Name("user")
+		mux.Post("/users/:id", fakeHandlerFunc())
+		admin := mux.Route("/admin")
+		admin.Get("/static/*filepath", fakeHandlerFunc())
+		mux.Get("/posts/:year?/:month?", fakeHandlerFunc())
+
+		if err := mux.Remove(http.MethodGet, "/users/:id"); err != nil {
+			t.Errorf("Remove() = %v", err)
+		}
+		if err := admin.Remove(http.MethodGet, "/static/*filepath"); err != nil {
+			t.Errorf("Remove() = %v", err)
+		}
+		if err := mux.Remove(http.MethodGet, "/posts/:year?/:month?"); err != nil {
+			t.Errorf("Remove() = %v", err)
+		}
+		for _, tt := range []struct{ method, path string }{
+			{http.MethodGet, "/users/:id"},
+			{http.MethodGet, "/users/:name"},
+			{http.MethodDelete, "/users"},
+			{http.MethodGet, "/nothing"},
+			{"GETT", "/users"},
+			{http.MethodGet, "users"},
+		} {
+			if err := mux.Remove(tt.method, tt.path); err == nil {
+				t.Errorf("Remove(%s, %s) should return error", tt.method, tt.path)
+			}
+		}
+
+		if _, err := mux.URL("user", "id", "1"); err == nil {
+			t.Errorf("name of the removed route should be removed too")
+		}
+		want := []string{"GET /users", "POST /users/:id"}
+		var got []string
+		mux.Walk(func(method, pattern string, h http.Handler) error {
+			got = append(got, method+" "+pattern)
+			return nil
+		})
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("routes after Remove = %v, want %v", got, want)
+		}
+
+		for _, tt := range []struct {
+			method, path string
+			code         int
+		}{
+			{http.MethodGet, "/users", http.StatusOK},
+			{http.MethodGet, "/users/1", http.StatusMethodNotAllowed},
+			{http.MethodPost, "/users/1", http.StatusOK},
+			{http.MethodGet, "/admin/static/a.js", http.StatusNotFound},
+			{http.MethodGet, "/posts", http.StatusNotFound},
+			{http.MethodGet, "/posts/2021", http.StatusNotFound},
+		} {
+			rw := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			mux.ServeHTTP(rw, req)
+			if rw.Code != tt.code {
+				t.Errorf("%s %s: expect %v status code, got %v", tt.method, tt.path, tt.code, rw.Code)
+			}
+		}
+
+		// 删除后可以重新注册
+		mux.Get("/users/:id", fakeHandlerFunc()).Name("user")
+
+		// 用展开后的路径删除带可选段的路由，所有展开后的路由都会被删除
+		mux.Get("/reports/:year/:month?", fakeHandlerFunc()).Name("report")
+		if err := mux.Remove(http.MethodGet, "/reports/:year"); err != nil {
+			t.Errorf("Remove() = %v", err)
+		}
+		for _, path := range []string{"/reports/2021", "/reports/2021/08"} {
+			rw := httptest.NewRecorder()
+			mux.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, path, nil))
+			if rw.Code != http.StatusNotFound {
+				t.Errorf("GET %s after Remove: expect 404 status code, got %v", path, rw.Code)
+			}
+		}
+		for _, ri := range mux.Routes() {
+			if ri.Name == "report" {
+				t.Errorf("Routes() after Remove should not list %s %s", ri.Method, ri.Pattern)
+			}
+		}
+
+		// 反复注册、删除路由时，被摘掉的节点会被复用，节点数量不会一直增长
+		cycle := func(i int) {
+			path := fmt.Sprintf("/tmp%d/:id/files/*filepath", i%3)
+			mux.Get(path, fakeHandlerFunc())
+			if err := mux.Remove(http.MethodGet, path); err != nil {
+				t.Errorf("Remove(%s) = %v", path, err)
+			}
+		}
+		for i := 0; i < 3; i++ {
+			cycle(i)
+		}
+		nodes := len(mux.load().nodes)
+		for i := 0; i < 1000; i++ {
+			cycle(i)
+		}
+		if n := len(mux.load().nodes); n != nodes {
+			t.Errorf("nodes after 1000 Handle/Remove cycles = %d, want %d", n, nodes)
+		}
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/users/1", nil)
+		mux.ServeHTTP(rw, req)
+		if rw.Code != http.StatusOK {
+			t.Errorf("GET /users/1 after Handle/Remove cycles: expect 200 status code, got %v", rw.Code)
+		}
+	}
+}
+
+func TestStrictSlash(t *testing.T) {
+	for _, opt := range []Option{WithStrictSlash(), WithRedirectSlash(http.StatusMovedPermanently)} {
+		mux := New(opt)
+		mux.Get("/", fakeHandlerFunc())
+		mux.Get("/users", fakeHandlerFunc())
+		mux.Get("/users/:id/", fakeHandlerFunc())
+		mux.Get("/docs/", fakeHandlerFunc())
+		mux.Get("/static/*filepath", fakeHandlerFunc())
+		mux.Get("/posts/:year?/", fakeHandlerFunc())
+		mux.Post("/form/", fakeHandlerFunc())
+
+		// 没有开启重定向时，末尾 '/' 不一致的请求和其他请求一样返回 404
+		redirect := mux.redirectSlash != 0
+		tests := []struct {
+			method, path string
+			code         int // 没有开启重定向时的状态码
+			location     string
+		}{
+			{http.MethodGet, "/", http.StatusOK, ""},
+			{http.MethodGet, "/users", http.StatusOK, ""},
+			{http.MethodGet, "/users/", http.StatusNotFound, "/users"},
+			{http.MethodGet, "/users/42/", http.StatusOK, ""},
+			{http.MethodGet, "/users/42?tab=posts", http.StatusNotFound, "/users/42/?tab=posts"},
+			{http.MethodGet, "/docs/", http.StatusOK, ""},
+			{http.MethodGet, "/docs", http.StatusNotFound, "/docs/"},
+			{http.MethodGet, "/static/", http.StatusOK, ""},
+			{http.MethodGet, "/static/a/", http.StatusOK, ""},
+			{http.MethodGet, "/posts/", http.StatusOK, ""},
+			{http.MethodGet, "/posts/2021/", http.StatusOK, ""},
+			{http.MethodGet, "/posts/2021", http.StatusNotFound, "/posts/2021/"},
+			{http.MethodPost, "/form", http.StatusNotFound, "/form/"},
+			{http.MethodGet, "/form", http.StatusNotFound, ""},
+			{http.MethodGet, "/nothing/", http.StatusNotFound, ""},
+		}
+		for _, tt := range tests {
+			code := tt.code
+			if redirect && tt.location != "" {
+				code = http.StatusMovedPermanently
+			}
+			rw := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			mux.ServeHTTP(rw, req)
+			if rw.Code != code {
+				t.Errorf("%s %s: expect %v status code, got %v", tt.method, tt.path, code, rw.Code)
+			}
+			if loc := rw.Header().Get("Location"); code == http.StatusMovedPermanently && loc != tt.location {
+				t.Errorf("%s %s: expect Location %#v, got %#v", tt.method, tt.path, tt.location, loc)
+			}
+		}
+
+		if err := mux.Remove(http.MethodGet, "/docs/"); err != nil {
+			t.Errorf("Remove() = %v", err)
+		}
+		if err := mux.Remove(http.MethodGet, "/users/"); err == nil {
+			t.Errorf("Remove(GET, /users/) should return error")
+		}
+	}
+
+	// 只有请求带有 RawPath 时，重定向的路径才保留编码
+	mux := New(WithRawPath(), WithRedirectSlash(http.StatusMovedPermanently))
+	mux.Get("/files/:name", fakeHandlerFunc())
+	for _, tt := range []struct{ path, rawPath, location string }{
+		{"/files/a/b/", "/files/a%2Fb/", "/files/a%2Fb"},
+		{"/files/a b/", "", "/files/a%20b"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.Path, req.URL.RawPath = tt.path, tt.rawPath
+		rw := httptest.NewRecorder()
+		mux.ServeHTTP(rw, req)
+		if loc := rw.Header().Get("Location"); rw.Code != http.StatusMovedPermanently || loc != tt.location {
+			t.Errorf("%s: expect redirect to %#v, got %v %#v", req.URL.EscapedPath(), tt.location, rw.Code, loc)
+		}
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	mux := New(WithCleanPath(http.StatusMovedPermanently))
+	mux.Get("/users/:id", fakeHandlerFunc())
+
+	tests := []struct {
+		path, location string
+	}{
+		{"/users/42", ""},
+		{"/users/42/", ""},
+		{"//users/42", "/users/42"},
+		{"/users//42?a=1", "/users/42?a=1"},
+		{"/users/./42/", "/users/42/"},
+		{"/posts/../users/42", "/users/42"},
+		{"/../../users/42", "/users/42"},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+		req.URL.Path, req.URL.RawQuery = tt.path, ""
+		if i := strings.IndexByte(tt.path, '?'); i != -1 {
+			req.URL.Path, req.URL.RawQuery = tt.path[:i], tt.path[i+1:]
+		}
+		mux.ServeHTTP(rw, req)
+		if tt.location == "" {
+			if rw.Code != http.StatusOK {
+				t.Errorf("%s: expect 200 status code, got %v", tt.path, rw.Code)
+			}
+			continue
+		}
+		if rw.Code != http.StatusMovedPermanently || rw.Header().Get("Location") != tt.location {
+			t.Errorf("%s: expect redirect to %#v, got %v %#v", tt.path, tt.location, rw.Code, rw.Header().Get("Location"))
+		}
+	}
+
+	// 开启 WithRawPath 时，编码的 '/' 是参数值的一部分，"a%2F..%2Fb" 不会被规范成 "b"
+	mux = New(WithCleanPath(http.StatusMovedPermanently), WithRawPath())
+	mux.Get("/files/:name", namedHandler("file"))
+	for _, tt := range []struct{ path, rawPath, want, location string }{
+		{"/files/a/../b", "/files/a%2F..%2Fb", "file &{{[name] [a/../b]}}", ""},
+		{"/files//a/b", "/files//a%2Fb", "", "/files/a%2Fb"},
+		{"/x/../files/a/b", "/x/../files/a%2Fb", "", "/files/a%2Fb"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.Path, req.URL.RawPath = tt.path, tt.rawPath
+		rw := httptest.NewRecorder()
+		mux.ServeHTTP(rw, req)
+		if tt.location != "" {
+			if loc := rw.Header().Get("Location"); rw.Code != http.StatusMovedPermanently || loc != tt.location {
+				t.Errorf("%s: expect redirect to %#v, got %v %#v", tt.rawPath, tt.location, rw.Code, loc)
+			}
+			continue
+		}
+		if rw.Code != http.StatusOK || rw.Body.String() != tt.want {
+			t.Errorf("%s: expect %#v, got %v %#v", tt.rawPath, tt.want, rw.Code, rw.Body.String())
+		}
+	}
+}
+
+func TestRawPath(t *testing.T) {
+	handle := func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, urlParams(r))
+	}
+	for _, raw := range []bool{false, true} {
+		mux := New()
+		if raw {
+			mux = New(WithRawPath())
+		}
+		mux.Get("/files/:name", handle)
+		mux.Get("/files/:name/info", handle)
+		mux.Get("/static/*filepath", handle)
+		mux.Get("/book/我/:title", handle)
+		mux.Get("/orders/:id{int}", handle)
+
+		tests := []struct {
+			path, rawPath string
+			want, rawWant string // 默认模式和 WithRawPath 时的响应，为空表示 404
+		}{
+			{"/files/a/b", "", "", ""},
+			{"/files/a/b", "/files/a%2Fb", "", "&{{[name] [a/b]}}"},
+			{"/files/a/b", "/files/a%2fb", "", "&{{[name] [a/b]}}"},
+			{"/files/a/b/info", "/files/a%2Fb/info", "", "&{{[name] [a/b]}}"},
+			{"/files/100%", "", "&{{[name] [100%]}}", "&{{[name] [100%]}}"},
+			{"/files/a%2Fb", "/files/a%252Fb", "&{{[name] [a%2Fb]}}", "&{{[name] [a%2Fb]}}"},
+			{"/static/a/b/c", "/static/a%2Fb/c", "&{{[filepath] [a/b/c]}}", "&{{[filepath] [a/b/c]}}"},
+			{"/book/我/你好", "", "&{{[title] [你好]}}", "&{{[title] [你好]}}"},
+			{"/book/我/你/好", "/book/%E6%88%91/%E4%BD%A0%2F%E5%A5%BD", "", "&{{[title] [你/好]}}"},
+			{"/orders/42", "/orders/%342", "&{{[id] [42]}}", "&{{[id] [42]}}"},
+			// RawPath 不是 Path 的合法编码时会被忽略
+			{"/files/a%zz", "/files/a%zz", "&{{[name] [a%zz]}}", "&{{[name] [a%zz]}}"},
+		}
+		for _, tt := range tests {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.URL.Path, req.URL.RawPath = tt.path, tt.rawPath
+			rw := httptest.NewRecorder()
+			mux.ServeHTTP(rw, req)
+			want := tt.want
+			if raw {
+				want = tt.rawWant
+			}
+			if want == "" {
+				if rw.Code != http.StatusNotFound {
+					t.Errorf("raw=%v %s: expect 404 status code, got %v %#v", raw, req.URL.EscapedPath(), rw.Code, rw.Body.String())
+				}
+				continue
+			}
+			if got := rw.Body.String(); got != want {
+				t.Errorf("raw=%v %s: expect %#v, got %#v", raw, req.URL.EscapedPath(), want, got)
+			}
+		}
+	}
+
+	// Mount 把编码后的剩余路径交给内层的 *Mux，和直接请求内层的结果相同
+	inner := New(WithRawPath())
+	inner.Get("/files/:name", namedHandler("file"))
+	outer := New(WithRawPath())
+	outer.Mount("/mnt", inner)
+	outer.Mount("/t/:tenant", inner)
+	for _, tt := range []struct {
+		handler       http.Handler
+		path, rawPath string
+		want          string
+	}{
+		{inner, "/files/a/b", "/files/a%2Fb", "file &{{[name] [a/b]}}"},
+		{outer, "/mnt/files/a/b", "/mnt/files/a%2Fb", "file &{{[name] [a/b]}}"},
+		{outer, "/t/acme/files/a/b", "/t/acme/files/a%2Fb", "file &{{[name tenant] [a/b acme]}}"},
+		{outer, "/mnt/files/a b", "", "file &{{[name] [a b]}}"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.Path, req.URL.RawPath = tt.path, tt.rawPath
+		rw := httptest.NewRecorder()
+		tt.handler.ServeHTTP(rw, req)
+		if rw.Code != http.StatusOK || rw.Body.String() != tt.want {
+			t.Errorf("%s: expect %#v, got %v %#v", req.URL.EscapedPath(), tt.want, rw.Code, rw.Body.String())
+		}
+	}
+}
+
+func TestIgnoreCase(t *testing.T) {
+	for _, redirect := range []bool{false, true} {
+		mux := New(WithIgnoreCase())
+		if redirect {
+			mux = New(WithCaseRedirect(http.StatusMovedPermanently))
+		}
+		mux.Get("/users/:id", namedHandler("user"))
+		mux.Get("/users/:id/Posts", namedHandler("posts"))
+		mux.Get("/API/v1/:name.:ext", namedHandler("file"))
+		mux.Get("/static/*filepath", namedHandler("static"))
+
+		tests := []struct {
+			path, want, location string
+		}{
+			{"/users/Bob", "user &{{[id] [Bob]}}", ""},
+			{"/Users/Bob", "user &{{[id] [Bob]}}", "/users/Bob"},
+			{"/USERS/Bob/", "user &{{[id] [Bob]}}", "/users/Bob/"},
+			{"/users/Bob/posts?page=2", "posts &{{[id] [Bob]}}", "/users/Bob/Posts?page=2"},
+			{"/api/V1/README.MD", "file &{{[name ext] [README MD]}}", "/API/v1/README.MD"},
+			{"/Static/CSS/Main.css", "static &{{[filepath] [CSS/Main.css]}}", "/static/CSS/Main.css"},
+		}
+		for _, tt := range tests {
+			rw := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, tt.path, nil)
+			mux.ServeHTTP(rw, req)
+			if redirect && tt.location != "" {
+				if rw.Code != http.StatusMovedPermanently || rw.Header().Get("Location") != tt.location {
+					t.Errorf("%s: expect redirect to %#v, got %v %#v", tt.path, tt.location, rw.Code, rw.Header().Get("Location"))
+				}
+				continue
+			}
+			if got := rw.Body.String(); got != tt.want {
+				t.Errorf("%s: expect %#v, got %#v", tt.path, tt.want, got)
+			}
+		}
+
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/usersX/1", nil)
+		mux.ServeHTTP(rw, req)
+		if rw.Code != http.StatusNotFound {
+			t.Errorf("/usersX/1: expect 404 status code, got %v", rw.Code)
+		}
+	}
+
+	// 大小写完全一致的静态路由优先，和注册顺序无关
+	mux := New(WithCaseRedirect(http.StatusMovedPermanently))
+	mux.Get("/Users/:id", namedHandler("user"))
+	mux.Get("/users/me", namedHandler("me"))
+	for _, tt := range []struct {
+		path, want, location string
+	}{
+		{"/users/me", "me <nil>", ""},
+		{"/Users/me", "user &{{[id] [me]}}", ""},
+		{"/USERS/me", "", "/Users/me"},
+		{"/users/42", "", "/Users/42"},
+	} {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, tt.path, nil)
+		mux.ServeHTTP(rw, req)
+		if tt.location != "" {
+			if rw.Code != http.StatusMovedPermanently || rw.Header().Get("Location") != tt.location {
+				t.Errorf("%s: expect redirect to %#v, got %v %#v", tt.path, tt.location, rw.Code, rw.Header().Get("Location"))
+			}
+			continue
+		}
+		if rw.Code != http.StatusOK || rw.Body.String() != tt.want {
+			t.Errorf("%s: expect %#v, got %v %#v", tt.path, tt.want, rw.Code, rw.Body.String())
+		}
+	}
+
+	// 默认区分大小写
+	mux = New()
+	mux.Get("/users/:id", fakeHandlerFunc())
+	rw := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/Users/1", nil)
+	mux.ServeHTTP(rw, req)
+	if rw.Code != http.StatusNotFound {
+		t.Errorf("/Users/1: expect 404 status code, got %v", rw.Code)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race
+// +build race
+
+package chu
+
+// raceEnabled 开启 -race 时 sync.Pool 会随机丢弃对象，内存分配相关的测试需要跳过
+const raceEnabled = true
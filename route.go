@@ -0,0 +1,324 @@
+package chu
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Route 注册到路由树上的路由，由 Mux.Handle 等方法返回
+// 子路由的中间件在第一次处理请求时才包到 handler 外面，
+// 所以子路由的 Use 和注册路由的先后顺序不影响结果
+type Route struct {
+	mux      *Mux
+	idx      int   // 路由在路由树中的节点编号
+	variants []int // 带可选段时展开后的所有节点编号，从短到长，最后一个就是 idx
+	method   string
+	pattern  string // 注册时的完整路径，包括子路由的前缀
+	handler  http.Handler
+	matchers []Matcher // 匹配条件，见 Mux.When
+
+	// Routes 中显示的完整路径，不包括 host，注册时由 routePattern 生成
+	fullPattern string
+
+	// 注册之后通过 Name、Meta 设置的属性（*routeAttrs），处理请求时原子地读取
+	attrs atomic.Value
+
+	once  sync.Once
+	chain http.Handler
+}
+
+// routeAttrs 路由的名字和元数据，修改时整体替换，不修改已经发布的 routeAttrs
+type routeAttrs struct {
+	name string
+	meta map[string]interface{}
+}
+
+// attributes 返回路由当前的属性
+func (rt *Route) attributes() *routeAttrs {
+	if attrs, _ := rt.attrs.Load().(*routeAttrs); attrs != nil {
+		return attrs
+	}
+	return &routeAttrs{}
+}
+
+func (rt *Route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.once.Do(func() {
+		rt.chain = rt.mux.chain(rt.handler)
+	})
+	rt.chain.ServeHTTP(w, r)
+}
+
+// needContext 判断处理请求时是否需要放入 Context，路由有元数据或者会经过中间件时需要，
+// 没有参数、元数据和中间件的路由只有 handler 自己，不需要从 Context 中读取
+func (rt *Route) needContext() bool {
+	if attrs, _ := rt.attrs.Load().(*routeAttrs); attrs != nil && attrs.meta != nil {
+		return true
+	}
+	return rt.mux.middlewareCount() > 0
+}
+
+// Name 给路由命名，之后可以通过 Mux.URL 生成这个路由的路径
+// 同一个 Mux（包括子路由和 Host 子路由）中名字不能重复
+func (rt *Route) Name(name string) *Route {
+	rt.mux.update(func(tb *table) {
+		if _, named := rt.mux.namedRoute(tb, name); named != nil {
+			panic("Already have route named " + name)
+		}
+		if tb.names == nil {
+			tb.names = make(map[string]*Route)
+		}
+		attrs := *rt.attributes()
+		attrs.name = name
+		rt.attrs.Store(&attrs)
+		tb.names[name] = rt
+	})
+	return rt
+}
+
+// Meta 给路由添加元数据，例如需要的权限、限流类别、负责的团队，
+// 处理请求时通过 RouteMeta 读取，中间件可以据此对不同路由做不同处理
+//
+//	mux.Get("/admin/users", listUsers).Meta("scope", "admin").Meta("rateLimit", "strict")
+//
+// 同一个 key 设置多次时使用最后一次的值
+func (rt *Route) Meta(key string, value interface{}) *Route {
+	rt.mux.update(func(tb *table) {
+		attrs := *rt.attributes()
+		meta := make(map[string]interface{}, len(attrs.meta)+1)
+		for k, v := range attrs.meta {
+			meta[k] = v
+		}
+		meta[key] = value
+		attrs.meta = meta
+		rt.attrs.Store(&attrs)
+	})
+	return rt
+}
+
+// namedRoute 依次在 tb、没有 host 限制的路由表和每个 Host 子路由的路由表中查找名字为 name 的路由，
+// 返回路由所在的路由表，找不到时返回 nil。在 update 中调用时 tb 是正在修改的路由表
+func (m *Mux) namedRoute(tb *table, name string) (*table, *Route) {
+	if rt, ok := tb.names[name]; ok {
+		return tb, rt
+	}
+	tables := []*table{m.tree.load()}
+	for _, h := range m.hostRouters() {
+		tables = append(tables, h.load())
+	}
+	for _, t := range tables {
+		if rt, ok := t.names[name]; ok {
+			return t, rt
+		}
+	}
+	return nil, nil
+}
+
+// URL 根据路由名字和参数生成路径，params 为参数名和参数值交替组成的列表，例如
+//
+//	mux.Get("/users/:id/posts/*rest", h).Name("posts")
+//	mux.URL("posts", "id", "42", "rest", "2021/08") // "/users/42/posts/2021/08"
+//
+// 参数值会经过转义，参数缺失或不满足约束时返回 error
+// 路由带可选段时，使用参数都存在的最长的展开路由，例如 "/reports/:year/:month?"
+// 只给出 year 时生成 "/reports/2021"
+// Host 子路由中命名的路由也可以生成，但只返回路径，不包括 host
+func (m *Mux) URL(name string, params ...string) (string, error) {
+	tb, rt := m.namedRoute(m.load(), name)
+	if rt == nil {
+		return "", fmt.Errorf("no route named %q", name)
+	}
+	if len(params)%2 != 0 {
+		return "", fmt.Errorf("params of route %q should be key-value pairs", name)
+	}
+
+	start := rt.idx
+	for i := len(rt.variants) - 1; i >= 0; i-- {
+		start = rt.variants[i]
+		if tb.hasParams(start, params) {
+			break
+		}
+	}
+
+	// 从路由节点往上找到根节点，依次得到每一部分
+	parts := make([]string, 0, 8)
+	for idx := start; idx != 0; idx = tb.nodes[idx].parent {
+		n := tb.nodes[idx]
+		if n.kind == staticNode {
+			parts = append(parts, escapePath(n.prefix))
+			continue
+		}
+		value, ok := lookupParam(params, n.key)
+		if !ok {
+			return "", fmt.Errorf("missing param %q for route %q", n.key, name)
+		}
+		if n.constraint != nil && !n.constraint.MatchString(value) {
+			return "", fmt.Errorf("param %q of route %q does not match %s", n.key, name, n.prefix)
+		}
+		if n.kind == catchAllNode {
+			parts = append(parts, escapePath(value))
+		} else {
+			parts = append(parts, url.PathEscape(value))
+		}
+	}
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(parts, ""), nil
+}
+
+// hasParams 判断从根节点到 idx 节点上的所有参数是否都在 params 中
+func (tb *table) hasParams(idx int, params []string) bool {
+	for ; idx != 0; idx = tb.nodes[idx].parent {
+		if n := tb.nodes[idx]; n.kind != staticNode {
+			if _, ok := lookupParam(params, n.key); !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// escapePath 转义路径中除 '/' 以外的字符
+func escapePath(path string) string {
+	parts := strings.Split(path, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return strings.Join(parts, "/")
+}
+
+// lookupParam 在参数名和参数值交替组成的列表中查找参数
+func lookupParam(params []string, key string) (string, bool) {
+	for i := 0; i+1 < len(params); i += 2 {
+		if params[i] == key {
+			return params[i+1], true
+		}
+	}
+	return "", false
+}
+
+// RouteInfo 路由信息，由 Mux.Routes 返回
+type RouteInfo struct {
+	Method      string
+	Pattern     string                 // 完整的路由路径，包括子路由的前缀
+	Name        string                 // 路由名字，没有命名时为空
+	Handler     string                 // handler 的函数名或类型名
+	Middlewares int                    // 处理这个路由时经过的中间件数量，包括根路由的中间件
+	Meta        map[string]interface{} // 路由的元数据，见 Route.Meta，不能修改
+}
+
+// Routes 按照路由树深度优先的顺序返回所有路由，同一路径下按照 HTTP Method 排序
+// Host 子路由的路由排在最后，Pattern 前面带有 host，例如 "api.example.com/users"
+func (m *Mux) Routes() []RouteInfo {
+	routes := make([]RouteInfo, 0)
+	m.walkRoutes(func(rt *Route, pattern string) error {
+		attrs := rt.attributes()
+		routes = append(routes, RouteInfo{
+			Method:      rt.method,
+			Pattern:     pattern,
+			Name:        attrs.name,
+			Handler:     handlerName(rt.handler),
+			Middlewares: rt.mux.middlewareCount(),
+			Meta:        attrs.meta,
+		})
+		return nil
+	})
+	return routes
+}
+
+// Walk 按照和 Routes 相同的顺序遍历所有路由，fn 返回 error 时停止遍历并返回这个 error
+// h 为注册时传入的 handler，不包括中间件
+func (m *Mux) Walk(fn func(method, pattern string, h http.Handler) error) error {
+	return m.walkRoutes(func(rt *Route, pattern string) error {
+		return fn(rt.method, pattern, rt.handler)
+	})
+}
+
+// walkRoutes 遍历路由树上的所有 *Route 及其完整路径
+// 根路由先遍历没有 host 限制的路由，再按照创建的顺序遍历 Host 子路由，
+// Host 子路由的路径前面加上 host，例如 "api.example.com/users"
+func (m *Mux) walkRoutes(fn func(rt *Route, pattern string) error) error {
+	if m.host != nil {
+		return m.host.load().walkRoutes(m.host.pattern, fn)
+	}
+	if err := m.tree.load().walkRoutes("", fn); err != nil {
+		return err
+	}
+	for _, h := range m.hostRouters() {
+		if err := h.load().walkRoutes(h.pattern, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkRoutes 遍历 tb 中的所有 *Route，路径前面加上 host
+func (tb *table) walkRoutes(host string, fn func(rt *Route, pattern string) error) error {
+	if len(tb.nodes) == 0 {
+		return nil
+	}
+	return tb.walk(0, func(idx int) error {
+		n := tb.nodes[idx]
+		for _, method := range tb.methods.list(n.allowMethods) {
+			for _, rt := range n.funcMap[tb.methods.codes[method]] {
+				// 带可选段的路由只在第一个还存在的展开路由上返回一次
+				if rt.variants != nil && tb.firstVariant(rt) != idx {
+					continue
+				}
+				if err := fn(rt, host+rt.fullPattern); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// routePattern 返回 Routes 中显示的 rt 的完整路径，不包括 host
+// 带可选段的路由使用注册时的写法，其他路由使用路由树中的路径
+func (tb *table) routePattern(rt *Route) string {
+	if rt.variants == nil {
+		return tb.pattern(rt.idx)
+	}
+	pattern := strings.TrimRight(rt.pattern, "/")
+	if tb.strictSlash && pattern != rt.pattern {
+		pattern += "/"
+	}
+	return pattern
+}
+
+// firstVariant 返回 rt 展开后的路由中第一个还在路由树上的节点编号
+func (tb *table) firstVariant(rt *Route) int {
+	mCode := tb.methods.codes[rt.method]
+	for _, idx := range rt.variants {
+		if n := tb.nodes[idx]; containsRoute(n.funcMap[mCode], rt) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// middlewareCount 返回经过 m 注册的路由会经过的中间件数量
+func (m *Mux) middlewareCount() int {
+	cnt := 0
+	for r := m; r != nil; r = r.parent {
+		cnt += len(r.middlewares)
+	}
+	return cnt
+}
+
+// handlerName 返回 handler 的函数名，不是函数时返回类型名
+func handlerName(h http.Handler) string {
+	if fn, ok := h.(http.HandlerFunc); ok {
+		if f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()); f != nil {
+			return f.Name()
+		}
+	}
+	return fmt.Sprintf("%T", h)
+}
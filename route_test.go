@@ -0,0 +1,240 @@
+package chu
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestURL(t *testing.T) {
+	mux := New()
+	mux.Get("/", fakeHandlerFunc()).Name("index")
+	mux.Get("/users/:id{int}", fakeHandlerFunc()).Name("user")
+	mux.Get("/users/:id/posts/*rest", fakeHandlerFunc()).Name("posts")
+	mux.Route("/admin").Get("/search/:q", fakeHandlerFunc()).Name("search")
+	mux.Get("/files/:name.:ext", fakeHandlerFunc()).Name("file")
+	mux.Get("/reports/:year/:month{int}?", fakeHandlerFunc()).Name("report")
+
+	tests := []struct {
+		name   string
+		params []string
+		want   string
+		err    bool
+	}{
+		{"index", nil, "/", false},
+		{"user", []string{"id", "42"}, "/users/42", false},
+		{"posts", []string{"id", "42", "rest", "2021/08 a"}, "/users/42/posts/2021/08%20a", false},
+		{"search", []string{"q", "a/b?c"}, "/admin/search/a%2Fb%3Fc", false},
+		{"file", []string{"name", "report", "ext", "pdf"}, "/files/report.pdf", false},
+		{"report", []string{"year", "2021", "month", "08"}, "/reports/2021/08", false},
+		{"report", []string{"year", "2021"}, "/reports/2021", false},
+		{"report", []string{"year", "2021", "month", "aug"}, "", true},
+		{"report", []string{"month", "08"}, "", true},
+		{"user", []string{"id", "abc"}, "", true},
+		{"user", []string{"name", "42"}, "", true},
+		{"user", []string{"id"}, "", true},
+		{"nothing", nil, "", true},
+	}
+	for _, tt := range tests {
+		got, err := mux.URL(tt.name, tt.params...)
+		if (err != nil) != tt.err {
+			t.Errorf("URL(%q, %q) error = %v, want error %v", tt.name, tt.params, err, tt.err)
+		}
+		if got != tt.want {
+			t.Errorf("URL(%q, %q) = %#v, want %#v", tt.name, tt.params, got, tt.want)
+		}
+	}
+
+	rec := catchPanic(func() {
+		mux.Post("/users", fakeHandlerFunc()).Name("user")
+	})
+	if recstr, _ := rec.(string); !regexp.MustCompile(`Already have route named .*`).MatchString(recstr) {
+		t.Errorf("got panic msg: '%v', but want 'Already have route named ...'", rec)
+	}
+}
+
+func listUsers(rw http.ResponseWriter, r *http.Request) {}
+
+type fileServer struct{}
+
+func (fileServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {}
+
+func TestRoutes(t *testing.T) {
+	mux := New()
+	mux.Use(headerMiddleware("X-Global", "1"))
+	mux.Get("/", fakeHandlerFunc())
+	mux.Post("/users", listUsers)
+	mux.Get("/users", listUsers).Name("users").Meta("scope", "users:read")
+	mux.With(headerMiddleware("X-With", "1")).Get("/users/:id{int}", listUsers)
+	mux.Handle(http.MethodGet, "/static/*filepath", fileServer{})
+	mux.Get("/users/:id{int}/posts/:page?/", listUsers)
+
+	want := []RouteInfo{
+		{http.MethodGet, "/", "", "github.com/alacine/chu.fakeHandlerFunc.func1", 1, nil},
+		{http.MethodGet, "/users", "users", "github.com/alacine/chu.listUsers", 1, map[string]interface{}{"scope": "users:read"}},
+		{http.MethodPost, "/users", "", "github.com/alacine/chu.listUsers", 1, nil},
+		{http.MethodGet, "/users/:id{int}", "", "github.com/alacine/chu.listUsers", 2, nil},
+		{http.MethodGet, "/users/:id{int}/posts/:page?", "", "github.com/alacine/chu.listUsers", 1, nil},
+		{http.MethodGet, "/static/*filepath", "", "chu.fileServer", 1, nil},
+	}
+	if got := mux.Routes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Routes() = %v, want %v", got, want)
+	}
+
+	// Walk 返回 error 时停止遍历
+	errStop := errors.New("stop")
+	var patterns []string
+	err := mux.Walk(func(method, pattern string, h http.Handler) error {
+		patterns = append(patterns, method+" "+pattern)
+		if pattern == "/users" {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Errorf("Walk() error = %v, want %v", err, errStop)
+	}
+	if want := []string{"GET /", "GET /users"}; !reflect.DeepEqual(patterns, want) {
+		t.Errorf("Walk() visited %v, want %v", patterns, want)
+	}
+}
+
+func TestRouteMeta(t *testing.T) {
+	mux := New(WithAutoHead())
+	// 根路由的中间件在路由匹配之前执行，下一个 handler 返回之后才能读取到
+	var owner interface{}
+	mux.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(rw, r)
+			owner = RouteMeta(r)["owner"]
+		})
+	})
+	api := mux.Route("/api")
+	api.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if scope, _ := RouteMeta(r)["scope"].(string); scope != "" {
+				rw.Header().Set("X-Scope", scope)
+			}
+			next.ServeHTTP(rw, r)
+		})
+	})
+	handle := func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, RouteMeta(r)["owner"])
+	}
+	api.Get("/users/:id", handle).Meta("scope", "users:read").Meta("owner", "accounts")
+	api.Delete("/users/:id", handle).Meta("scope", "users:write").Meta("scope", "admin")
+	api.Get("/health", handle)
+
+	for _, tt := range []struct {
+		method, path, scope, body string
+		owner                     interface{}
+	}{
+		{http.MethodGet, "/api/users/1", "users:read", "accounts", "accounts"},
+		{http.MethodHead, "/api/users/1", "users:read", "", "accounts"},
+		{http.MethodDelete, "/api/users/1", "admin", "<nil>", nil},
+		{http.MethodGet, "/api/health", "", "<nil>", nil},
+		{http.MethodGet, "/nothing", "", "404 page not found\n", nil},
+	} {
+		owner = "unset"
+		rw := httptest.NewRecorder()
+		mux.ServeHTTP(rw, httptest.NewRequest(tt.method, tt.path, nil))
+		if got := rw.Header().Get("X-Scope"); got != tt.scope {
+			t.Errorf("%s %s: expect scope %#v, got %#v", tt.method, tt.path, tt.scope, got)
+		}
+		if got := rw.Body.String(); got != tt.body {
+			t.Errorf("%s %s: expect %#v, got %#v", tt.method, tt.path, tt.body, got)
+		}
+		if owner != tt.owner {
+			t.Errorf("%s %s: expect root middleware to read owner %v, got %v", tt.method, tt.path, tt.owner, owner)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/users/1", nil)
+	if meta := RouteMeta(req); meta != nil {
+		t.Errorf("RouteMeta() without Context = %v, want nil", meta)
+	}
+
+	want := map[string]interface{}{"scope": "users:read", "owner": "accounts"}
+	for _, info := range mux.Routes() {
+		if info.Method == http.MethodGet && info.Pattern == "/api/users/:id" && !reflect.DeepEqual(info.Meta, want) {
+			t.Errorf("Routes() meta = %v, want %v", info.Meta, want)
+		}
+	}
+}
+
+func TestRoutePattern(t *testing.T) {
+	handle := func(rw http.ResponseWriter, r *http.Request) {
+		ctx := r.Context().Value(ContextKey).(*Context)
+		fmt.Fprint(rw, ctx.RouteMethod(), " ", RoutePattern(r))
+	}
+	mux := New(WithAutoHead())
+	// 根路由的中间件在下一个 handler 返回之后读取，适合在日志中记录
+	var logged string
+	mux.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(rw, r)
+			logged = RoutePattern(r)
+		})
+	})
+	mux.Get("/users/:id", handle)
+	mux.Route("/api").Group("/v1", func(r *Mux) {
+		r.Get("/posts/:id{int}", handle)
+		r.Get("/reports/:year/:month?", handle)
+	})
+	mux.Host(":tenant.example.com").Get("/projects/:id", handle)
+
+	sub := New()
+	sub.Get("/items/:id", handle)
+	sub.Mount("/raw", http.HandlerFunc(handle))
+	mux.Mount("/shop/:shop", sub)
+	// 挂载的 Mux 有自己的根路由中间件
+	audited := New()
+	audited.Use(func(next http.Handler) http.Handler { return next })
+	audited.Get("/orders/:id", handle)
+	mux.Mount("/audit", audited)
+
+	for _, tt := range []struct {
+		method, host, path, want, logged string
+	}{
+		{http.MethodGet, "example.com", "/users/1", "GET /users/:id", "/users/:id"},
+		{http.MethodHead, "example.com", "/users/1", "", "/users/:id"},
+		{http.MethodGet, "example.com", "/api/v1/posts/2", "GET /api/v1/posts/:id{int}", "/api/v1/posts/:id{int}"},
+		{http.MethodGet, "example.com", "/api/v1/reports/2021", "GET /api/v1/reports/:year/:month?", "/api/v1/reports/:year/:month?"},
+		{http.MethodGet, "acme.example.com", "/projects/7", "GET :tenant.example.com/projects/:id", ":tenant.example.com/projects/:id"},
+		{http.MethodGet, "example.com", "/shop/a/items/3", "GET /shop/:shop/items/:id", "/shop/:shop/items/:id"},
+		{http.MethodPost, "example.com", "/shop/a/raw/x", "POST /shop/:shop/raw/*" + mountKey, "/shop/:shop/raw/*" + mountKey},
+		{http.MethodGet, "example.com", "/audit/orders/5", "GET /audit/orders/:id", "/audit/orders/:id"},
+		{http.MethodGet, "example.com", "/nothing", "404 page not found\n", ""},
+	} {
+		logged = "unset"
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Host = tt.host
+		mux.ServeHTTP(rw, req)
+		if got := rw.Body.String(); got != tt.want {
+			t.Errorf("%s %s%s: expect %#v, got %#v", tt.method, tt.host, tt.path, tt.want, got)
+		}
+		if logged != tt.logged {
+			t.Errorf("%s %s%s: expect root middleware to read %#v, got %#v", tt.method, tt.host, tt.path, tt.logged, logged)
+		}
+	}
+
+	// 处理 HEAD 请求的是 GET 路由
+	mux.Get("/head/:id", func(rw http.ResponseWriter, r *http.Request) {
+		ctx := r.Context().Value(ContextKey).(*Context)
+		rw.Header().Set("X-Route", ctx.RouteMethod()+" "+RoutePattern(r))
+	})
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, httptest.NewRequest(http.MethodHead, "/head/1", nil))
+	if got := rw.Header().Get("X-Route"); got != "GET /head/:id" {
+		t.Errorf("HEAD /head/1: expect route %#v, got %#v", "GET /head/:id", got)
+	}
+
+	if got := RoutePattern(httptest.NewRequest(http.MethodGet, "/users/1", nil)); got != "" {
+		t.Errorf("RoutePattern() without Context = %#v, want empty", got)
+	}
+}
@@ -2,13 +2,16 @@ package chu
 
 import (
 	"errors"
-	"fmt"
 	"net/http"
+	"regexp"
 	"strings"
+	"sync"
 )
 
-type methodType uint
+// methodType 路由可以处理的 HTTP Method 的集合，每一位对应 methodSet 中的一个 Method
+type methodType uint64
 
+// 内置的 HTTP Method 对应的位，和 defaultMethods 中的顺序一致
 const (
 	mGET methodType = 1 << iota
 	mPOST
@@ -21,167 +24,987 @@ const (
 	mTRACE
 )
 
-var methodMap = map[string]methodType{
-	http.MethodGet:     mGET,
-	http.MethodPost:    mPOST,
-	http.MethodPut:     mPUT,
-	http.MethodDelete:  mDELETE,
-	http.MethodHead:    mHEAD,
-	http.MethodOptions: mOPTION,
-	http.MethodPatch:   mPATCH,
-	http.MethodConnect: mCONNECT,
-	http.MethodTrace:   mTRACE,
+// maxMethods methodType 最多能表示的 HTTP Method 数量
+const maxMethods = 64
+
+// methodSet 路由树可以注册的 HTTP Method，包括内置的 Method 和 WithMethods 添加的扩展 Method
+// 创建之后不再修改，添加扩展 Method 时生成新的 methodSet
+type methodSet struct {
+	names []string // 第 i 个 Method 对应 methodType 的第 i 位
+	codes map[string]methodType
+}
+
+// defaultMethods 内置的 HTTP Method
+var defaultMethods = newMethodSet(
+	http.MethodGet,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodDelete,
+	http.MethodHead,
+	http.MethodOptions,
+	http.MethodPatch,
+	http.MethodConnect,
+	http.MethodTrace,
+)
+
+// newMethodSet 按照顺序给每个 Method 分配一位
+func newMethodSet(names ...string) *methodSet {
+	s := &methodSet{
+		names: names,
+		codes: make(map[string]methodType, len(names)),
+	}
+	for i, name := range names {
+		s.codes[name] = 1 << uint(i)
+	}
+	return s
+}
+
+// with 返回加上 names 之后的 methodSet，已有的 Method 保持原来的位
+// 加上之后超过 maxMethods 时返回 false
+func (s *methodSet) with(names ...string) (*methodSet, bool) {
+	list := append([]string(nil), s.names...)
+	for _, name := range names {
+		if !containsString(list, name) {
+			list = append(list, name)
+		}
+	}
+	if len(list) > maxMethods {
+		return nil, false
+	}
+	return newMethodSet(list...), true
+}
+
+// list 返回 mt 中包含的所有 HTTP Method，按照 methodSet 中的顺序排列
+func (s *methodSet) list(mt methodType) []string {
+	list := make([]string, 0, len(s.names))
+	for i, method := range s.names {
+		if mt&(1<<uint(i)) != 0 {
+			list = append(list, method)
+		}
+	}
+	return list
+}
+
+// all 返回包含所有 Method 的 methodType
+func (s *methodSet) all() methodType {
+	return methodType(1<<uint(len(s.names)) - 1)
+}
+
+// validMethod 判断 method 是否为合法的 HTTP Method（RFC 7230 中的 token）
+func validMethod(method string) bool {
+	if method == "" {
+		return false
+	}
+	for i := 0; i < len(method); i++ {
+		c := method[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// containsString 判断 list 中是否有 s
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// errStopWalk 用于提前结束 walk
+var errStopWalk = errors.New("stop walk")
+
+// paramTypes 参数段中可以使用的内置约束类型，例如 ":id{int}"
+var paramTypes = map[string]string{
+	"int":   `[0-9]+`,
+	"uuid":  `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+	"alpha": `[a-zA-Z]+`,
+	"hex":   `[0-9a-fA-F]+`,
+}
+
+// table 路由表，保存路由树的所有节点和命名路由
+// 开启 WithHotSwap 后，已经发布的 table 不会再被修改，修改路由时先复制一份新的 table，
+// 只复制修改到的节点（见 mutable），修改完成后再原子地替换掉旧的 table
+type table struct {
+	// 所有节点，按需增长，编号为 0 的节点是根节点
+	// 节点之间通过编号互相引用，复制 table 时编号保持不变
+	nodes []*node
+	// 从路由树上摘掉的节点编号，newNode 优先复用，避免反复注册、删除路由时 nodes 一直增长
+	free []int
+
+	// 命名路由，用于 Mux.URL 反向生成路径
+	names map[string]*Route
+
+	// table 的版本号，节点的版本号和 table 相同时表示节点属于这个 table，可以直接修改
+	gen uint64
+
+	// Context 池，和 tree.contextPool 相同
+	pool *sync.Pool
+
+	// 可以注册的 HTTP Method，由 WithMethods 设置
+	methods *methodSet
+
+	// 路径末尾的 '/' 是否有区别，由 WithStrictSlash 设置
+	strictSlash bool
+	// 静态文本是否忽略大小写，由 WithIgnoreCase 设置
+	ignoreCase bool
+}
+
+// clone 复制一份新的 table，新 table 和旧 table 共享所有节点，直到节点被修改
+func (tb *table) clone() *table {
+	c := &table{
+		nodes: make([]*node, len(tb.nodes), cap(tb.nodes)),
+		free:  append([]int(nil), tb.free...),
+		names: make(map[string]*Route, len(tb.names)),
+		gen:   tb.gen + 1,
+		pool:  tb.pool,
+
+		methods:     tb.methods,
+		strictSlash: tb.strictSlash,
+		ignoreCase:  tb.ignoreCase,
+	}
+	copy(c.nodes, tb.nodes)
+	for name, rt := range tb.names {
+		c.names[name] = rt
+	}
+	return c
+}
+
+// mutable 返回可以修改的 idx 节点
+// 节点属于更早的 table 时，复制一份节点放到 tb 中，避免修改正在被读取的节点
+func (tb *table) mutable(idx int) *node {
+	n := tb.nodes[idx]
+	if n.gen == tb.gen {
+		return n
+	}
+	c := *n
+	c.gen = tb.gen
+	c.indices = append([]byte(nil), n.indices...)
+	c.statics = append([]int(nil), n.statics...)
+	c.params = append([]int(nil), n.params...)
+	if n.funcMap != nil {
+		c.funcMap = make(map[methodType][]*Route, len(n.funcMap))
+		for k, v := range n.funcMap {
+			c.funcMap[k] = append([]*Route(nil), v...)
+		}
+	}
+	tb.nodes[idx] = &c
+	return &c
 }
 
+// nodeKind 节点类型
+type nodeKind uint8
+
+const (
+	staticNode   nodeKind = iota // 静态节点，prefix 为一段静态文本，可以跨越多个 '/'
+	paramNode                    // 参数节点 :name，匹配到下一个 '/' 为止
+	catchAllNode                 // 通配节点 *name，匹配剩余的所有路径
+)
+
+// node 压缩前缀树（radix tree）的节点
+// 静态节点之间共享公共前缀，例如 "/users" 和 "/uploads" 会拆成 "/u"、"sers"、"ploads" 三个节点
 type node struct {
-	seg          string
-	wildcard     bool
-	wildchild    bool
-	level        int
+	kind   nodeKind
+	prefix string // 静态节点的文本，参数节点和通配节点为参数本身，例如 ":id{int}"、"*filepath"
+	parent int    // 父节点编号，根节点没有父节点
+
+	key        string         // 参数名，只有参数节点和通配节点才有
+	constraint *regexp.Regexp // 参数约束，为 nil 时不做限制
+
+	// 子节点，匹配时按照 静态节点 > 带约束的参数节点 > 参数节点 > 通配节点 的顺序尝试
+	indices  []byte // 静态子节点 prefix 的首字节，和 statics 一一对应，用来快速查找静态子节点
+	statics  []int
+	params   []int // 参数子节点，带约束的在前，没有约束的最多只有一个且排在最后
+	catchAll int   // 通配子节点，为 0 时表示没有（根节点不会是子节点）
+
 	allowMethods methodType
-	funcMap      map[methodType]*http.Handler
+	// 每个方法注册的路由，带匹配条件的按照注册顺序排在前面，没有条件的最多只有一个且排在最后
+	funcMap map[methodType][]*Route
+
+	gen uint64 // 节点所属 table 的版本号
 }
 
-// dfs 按照深度优先顺序打出所有可用路由
-func dfs(idx int, nodes []*node, nex [][]int, printSegs []string) {
-	if ams := nodes[idx].allowMethods; ams != 0 {
-		//fmt.Printf("%d %#v %v\n", idx, strings.Join(printSegs, "/"), nex[idx])
-		mList := make([]string, 0, len(methodMap))
-		for k, v := range methodMap {
-			if ams&v != 0 {
-				mList = append(mList, k)
-			}
+// staticChild 根据首字节查找静态子节点，返回在 indices 中的序号，找不到时返回 -1
+func (n *node) staticChild(c byte) int {
+	for i, b := range n.indices {
+		if b == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// inner 判断是否有和自己在同一段中的静态子节点，例如 ":name.:ext" 中的 ":name" 有 "."
+func (n *node) inner() bool {
+	for _, b := range n.indices {
+		if b != '/' {
+			return true
 		}
-		fmt.Printf("%d %#v %v\n", idx, strings.Join(printSegs, "/"), mList)
 	}
-	for _, i := range nex[idx] {
-		printSegs = append(printSegs, nodes[i].seg)
-		l := len(printSegs)
-		dfs(i, nodes, nex, printSegs)
-		printSegs = printSegs[:l-1]
+	return false
+}
+
+// newNode 把节点加入路由树，返回节点编号，有被摘掉的节点时复用它的编号
+// 复用只替换 tb.nodes 中的节点，开启 WithHotSwap 时旧的 table 有自己的 nodes，不受影响
+func (tb *table) newNode(n *node) int {
+	n.gen = tb.gen
+	if last := len(tb.free) - 1; last >= 0 {
+		idx := tb.free[last]
+		tb.free = tb.free[:last]
+		tb.nodes[idx] = n
+		return idx
 	}
-	printSegs = []string{""}
+	tb.nodes = append(tb.nodes, n)
+	return len(tb.nodes) - 1
 }
 
-// addMethodNode 添加一个节点
-// path: 完整的注册路径
-// nodes: 所有节点
-// nex: 节点邻接表
-func addMethodToNode(method string, path string, handle http.Handler, nodes *[]*node, nex *[][]int) {
+// walk 按照深度优先、和匹配相同的顺序遍历 idx 及其所有子节点，fn 返回 error 时停止遍历
+func (tb *table) walk(idx int, fn func(idx int) error) error {
+	if err := fn(idx); err != nil {
+		return err
+	}
+	n := tb.nodes[idx]
+	for _, i := range n.statics {
+		if err := tb.walk(i, fn); err != nil {
+			return err
+		}
+	}
+	for _, i := range n.params {
+		if err := tb.walk(i, fn); err != nil {
+			return err
+		}
+	}
+	if n.catchAll != 0 {
+		return tb.walk(n.catchAll, fn)
+	}
+	return nil
+}
+
+// pattern 返回从根节点到 idx 节点组成的路由路径
+func (tb *table) pattern(idx int) string {
+	parts := make([]string, 0, 8)
+	for ; idx != 0; idx = tb.nodes[idx].parent {
+		parts = append(parts, tb.nodes[idx].prefix)
+	}
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(parts, "")
+}
+
+// addRoute 注册路由，返回注册了 handle 的节点编号
+// path: 完整的注册路径，带可选段时展开成多个路由注册，按照从短到长的顺序返回所有节点编号
+// 出错时路由树不会有任何改动
+func (tb *table) addRoute(method string, path string, rt *Route) ([]int, error) {
+	mCode, tokenList, err := tb.prepareRoute(method, path, len(rt.matchers) != 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(tb.nodes) == 0 {
+		tb.newNode(&node{})
+	}
+
+	idxs := make([]int, 0, len(tokenList))
+	for _, tokens := range tokenList {
+		idx := 0
+		for _, tok := range tokens {
+			if isWildcard(tok) {
+				idx = tb.insertParam(idx, tok)
+			} else {
+				idx = tb.insertStatic(idx, tok)
+			}
+		}
+
+		n := tb.mutable(idx)
+		n.allowMethods |= mCode
+		if n.funcMap == nil {
+			n.funcMap = make(map[methodType][]*Route)
+		}
+		routes := append(n.funcMap[mCode], rt)
+		if last := len(routes) - 1; last > 0 && len(routes[last-1].matchers) == 0 {
+			// 没有条件的路由保持在最后
+			routes[last-1], routes[last] = routes[last], routes[last-1]
+		}
+		n.funcMap[mCode] = routes
+		idxs = append(idxs, idx)
+	}
+	return idxs, nil
+}
+
+// prepareRoute 检查路由能否注册，返回 Method 对应的 methodType 和每个展开路由的 patternTokens
+// 只读取路由树，不做任何修改
+func (tb *table) prepareRoute(method, path string, conditional bool) (methodType, [][]string, error) {
 	segs, err := pathToSegs(path)
 	if err != nil {
-		panic(err)
+		return 0, nil, &RouteError{Err: ErrInvalidPath, Method: method, Pattern: path, Reason: err.Error()}
 	}
-	idx := getLastMatchedNodeIdx(segs, *nodes, *nex)
-	mCode, ok := methodMap[method]
+	mCode, ok := tb.methods.codes[method]
 	if !ok {
-		panic("No such HTTP Method called: " + method)
+		return 0, nil, &RouteError{Err: ErrUnknownMethod, Method: method, Pattern: path}
+	}
+	variants := expandOptional(segs)
+	tokenList := make([][]string, len(variants))
+	for i, segs := range variants {
+		tokenList[i] = tb.routeTokens(path, segs)
+		if err := tb.checkRoute(method, path, mCode, conditional, tokenList[i]); err != nil {
+			return 0, nil, err
+		}
+	}
+	return mCode, tokenList, nil
+}
+
+// routeTokens 返回 segs 对应的 patternTokens
+// 开启 strictSlash 时，path 末尾的 '/' 也作为路由的一部分，通配段后面的 '/' 除外
+func (tb *table) routeTokens(path string, segs []string) []string {
+	tokens := patternTokens(segs)
+	last := len(tokens) - 1
+	if !tb.strictSlash || len(segs) == 1 || path[len(path)-1] != '/' || isCatchAll(tokens[last]) {
+		return tokens
 	}
-	lastNode := (*nodes)[idx]
-	if lastNode.level == len(segs)-1 && lastNode.allowMethods&mCode != 0 {
-		panic("Already have handle func for " + path + " with " + method)
+	if isWildcard(tokens[last]) {
+		return append(tokens, "/")
 	}
-	if (*lastNode).level < len(segs)-1 {
-		// si 是 segs 中第一个不匹配的序号
-		si := lastNode.level + 1
+	tokens[last] += "/"
+	return tokens
+}
 
-		// 通配符类型节点作为子节点时，该节点只允许有一个子节点
-		if len((*nex)[idx]) != 0 && (lastNode.wildchild || isWildcard(segs[si])) {
-			panic("Conflict between " + path + " and " +
-				strings.Join(segs[:si], "/") + "/" +
-				(*nodes)[(*nex)[idx][0]].seg)
+// expandOptional 把末尾的可选段展开，按照从短到长的顺序返回所有组合，返回的段中去掉了 '?'，例如
+// ["", "reports", ":year", ":month?"] => [["", "reports", ":year"], ["", "reports", ":year", ":month"]]
+func expandOptional(segs []string) [][]string {
+	first := len(segs)
+	for first > 1 && isOptional(segs[first-1]) {
+		first--
+	}
+	if first == len(segs) {
+		return [][]string{segs}
+	}
+	clean := make([]string, len(segs))
+	for i, seg := range segs {
+		clean[i] = strings.TrimSuffix(seg, "?")
+	}
+	variants := make([][]string, 0, len(segs)-first+1)
+	for i := first; i <= len(segs); i++ {
+		variants = append(variants, clean[:i])
+	}
+	return variants
+}
+
+// checkRoute 在修改路由树之前检查新路由是否和已有路由冲突或重复
+// 带匹配条件（conditional）的路由不会和同一路径、同一方法的路由重复
+func (tb *table) checkRoute(method, path string, mCode methodType, conditional bool, tokens []string) error {
+	idx, i := tb.descend(tokens)
+	if idx == -1 {
+		return nil
+	}
+	if i == len(tokens) {
+		if routes := tb.nodes[idx].funcMap[mCode]; !conditional && len(routes) != 0 && len(routes[len(routes)-1].matchers) == 0 {
+			return &RouteError{Err: ErrDuplicateRoute, Method: method, Pattern: path, Existing: tb.pattern(idx)}
+		}
+		return nil
+	}
+
+	// 和已有节点分叉的位置是参数段或通配段时才可能冲突
+	n, tok := tb.nodes[idx], tokens[i]
+	conflict := 0
+	switch {
+	case isCatchAll(tok):
+		conflict = n.catchAll
+	case isWildcard(tok):
+		_, constraint, _ := parseParam(tok)
+		if last := len(n.params) - 1; constraint == nil && last >= 0 && tb.nodes[n.params[last]].constraint == nil {
+			conflict = n.params[last]
 		}
-		idx = createNodes(idx, segs[si:], nodes, nex)
-		lastNode = (*nodes)[idx]
 	}
-	lastNode.allowMethods |= mCode
-	if lastNode.funcMap == nil {
-		lastNode.funcMap = make(map[methodType]*http.Handler)
+	if conflict != 0 {
+		return &RouteError{Err: ErrConflict, Method: method, Pattern: path, Existing: tb.firstRoute(conflict)}
 	}
-	lastNode.funcMap[mCode] = &handle
+	return nil
+}
+
+// firstRoute 返回 idx 节点下第一个注册了 handler 的路由路径
+func (tb *table) firstRoute(idx int) string {
+	found := idx
+	tb.walk(idx, func(i int) error {
+		if tb.nodes[i].allowMethods != 0 {
+			found = i
+			return errStopWalk
+		}
+		return nil
+	})
+	return tb.pattern(found)
 }
 
-// getLastMatchedNodeIdx 返回最后一个匹配的节点
-func getLastMatchedNodeIdx(segs []string, nodes []*node, nex [][]int) int {
-	if len(segs) == 0 {
-		return 0
+// patternTokens 把 pathToSegs 得到的段重新组合成静态文本和参数，
+// 参数和通配段以 ':'、'*' 开头，其余为静态文本，例如
+// "/users/:id/posts" => ["/users/", ":id", "/posts"]
+// "/files/:name.:ext" => ["/files/", ":name", ".", ":ext"]
+func patternTokens(segs []string) []string {
+	if len(segs) == 1 {
+		return []string{"/"}
 	}
-	si, idx := 1, 0
-	for si < len(segs) {
-		// a, b 只是 si 和 idx 的一个备份，用来检测 si 和 idx 是否发生变化
-		a, b := si, idx
-		for _, i := range nex[idx] {
-			if nodes[i].seg == segs[si] {
-				si, idx = si+1, i
-				break
+	tokens := make([]string, 0, len(segs))
+	static := ""
+	for _, seg := range segs[1:] {
+		static += "/"
+		// pathToSegs 已经校验过，这里不会出错
+		parts, _ := splitSegment(seg)
+		for _, part := range parts {
+			if !isWildcard(part) {
+				static += part
+				continue
 			}
+			tokens = append(tokens, static, part)
+			static = ""
 		}
-		if a == si || b == idx {
+	}
+	if static != "" {
+		tokens = append(tokens, static)
+	}
+	return tokens
+}
+
+// insertStatic 在 idx 节点下插入静态文本，必要时拆分已有节点，返回最后一个节点的编号
+func (tb *table) insertStatic(idx int, text string) int {
+	for len(text) > 0 {
+		ci := tb.nodes[idx].staticChild(text[0])
+		if ci == -1 {
+			child := tb.newNode(&node{kind: staticNode, prefix: text, parent: idx})
+			n := tb.mutable(idx)
+			n.indices = append(n.indices, text[0])
+			n.statics = append(n.statics, child)
+			return child
+		}
+
+		child := tb.nodes[idx].statics[ci]
+		l := commonPrefix(tb.nodes[child].prefix, text)
+		if l < len(tb.nodes[child].prefix) {
+			// 拆分子节点，公共前缀作为新的中间节点
+			n, c := tb.mutable(idx), tb.mutable(child)
+			mid := tb.newNode(&node{
+				kind:    staticNode,
+				prefix:  c.prefix[:l],
+				parent:  idx,
+				indices: []byte{c.prefix[l]},
+				statics: []int{child},
+			})
+			n.statics[ci] = mid
+			c.prefix, c.parent = c.prefix[l:], mid
+			child = mid
+		}
+		idx, text = child, text[l:]
+	}
+	return idx
+}
+
+// insertParam 在 idx 节点下插入参数节点或通配节点，返回这个节点的编号
+// 同一位置上只允许有一个没有约束的参数节点和一个通配节点，带约束的参数节点不受限制
+// 冲突已经由 checkRoute 检查过
+func (tb *table) insertParam(idx int, seg string) int {
+	n := tb.nodes[idx]
+	if isCatchAll(seg) {
+		if n.catchAll != 0 {
+			return n.catchAll
+		}
+		key, _, _ := parseParam(seg)
+		child := tb.newNode(&node{kind: catchAllNode, prefix: seg, parent: idx, key: key})
+		tb.mutable(idx).catchAll = child
+		return child
+	}
+
+	for _, i := range n.params {
+		if tb.nodes[i].prefix == seg {
+			return i
+		}
+	}
+	// pathToSegs 已经校验过，这里不会出错
+	key, constraint, _ := parseParam(seg)
+	last := len(n.params) - 1
+	child := tb.newNode(&node{kind: paramNode, prefix: seg, parent: idx, key: key, constraint: constraint})
+	n = tb.mutable(idx)
+	n.params = append(n.params, child)
+	if constraint != nil && last >= 0 && tb.nodes[n.params[last]].constraint == nil {
+		n.params[last], n.params[last+1] = n.params[last+1], n.params[last]
+	}
+	return child
+}
+
+// removeRoute 删除路由，返回被删除的路由，包括同一路径、同一方法上带匹配条件的路由，
+// 被删除的路由带可选段时删除它所有展开后的路由
+// 删除后没有方法也没有子节点的节点会从路由树上摘掉，编号留给之后新加的节点复用
+func (tb *table) removeRoute(method, path string) ([]*Route, error) {
+	segs, err := pathToSegs(path)
+	if err != nil {
+		return nil, &RouteError{Err: ErrInvalidPath, Method: method, Pattern: path, Reason: err.Error()}
+	}
+	mCode, ok := tb.methods.codes[method]
+	if !ok {
+		return nil, &RouteError{Err: ErrUnknownMethod, Method: method, Pattern: path}
+	}
+	variants := expandOptional(segs)
+	idx := tb.lookup(tb.routeTokens(path, variants[len(variants)-1]))
+	if idx == -1 || tb.nodes[idx].allowMethods&mCode == 0 {
+		return nil, errors.New("No handle func for " + path + " with " + method)
+	}
+	removed := tb.nodes[idx].funcMap[mCode]
+	tb.removeNode(idx, mCode, removed)
+
+	// 带可选段的路由删除所有展开后的路由，不管 path 是其中的哪一个，
+	// 例如 "/reports/:year" 也会删除 "/reports/:year/:month?" 注册的 "/reports/:year/:month"
+	for _, rt := range removed {
+		for i := len(rt.variants) - 1; i >= 0; i-- {
+			if v := rt.variants[i]; containsRoute(tb.nodes[v].funcMap[mCode], rt) {
+				tb.removeNode(v, mCode, []*Route{rt})
+			}
+		}
+	}
+	return removed, nil
+}
+
+// removeNode 从 idx 节点上 mCode 对应的路由中删除 routes，并摘掉删除后没有方法也没有子节点的节点
+func (tb *table) removeNode(idx int, mCode methodType, routes []*Route) {
+	n := tb.mutable(idx)
+	var kept []*Route
+	for _, rt := range n.funcMap[mCode] {
+		if !containsRoute(routes, rt) {
+			kept = append(kept, rt)
+		}
+	}
+	if len(kept) != 0 {
+		n.funcMap[mCode] = kept
+		return
+	}
+	n.allowMethods &^= mCode
+	delete(n.funcMap, mCode)
+
+	for idx != 0 {
+		n := tb.nodes[idx]
+		if n.allowMethods != 0 || len(n.statics) != 0 || len(n.params) != 0 || n.catchAll != 0 {
 			break
 		}
+		p := tb.mutable(n.parent)
+		switch n.kind {
+		case staticNode:
+			ci := p.staticChild(n.prefix[0])
+			p.indices = append(p.indices[:ci], p.indices[ci+1:]...)
+			p.statics = append(p.statics[:ci], p.statics[ci+1:]...)
+		case paramNode:
+			for i, c := range p.params {
+				if c == idx {
+					p.params = append(p.params[:i], p.params[i+1:]...)
+					break
+				}
+			}
+		case catchAllNode:
+			p.catchAll = 0
+		}
+		tb.free = append(tb.free, idx)
+		idx = n.parent
+	}
+}
+
+// containsRoute 判断 routes 中是否有 rt
+func containsRoute(routes []*Route, rt *Route) bool {
+	for _, r := range routes {
+		if r == rt {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup 返回和注册时的 patternTokens 完全相同的节点编号，找不到时返回 -1
+func (tb *table) lookup(tokens []string) int {
+	idx, i := tb.descend(tokens)
+	if i != len(tokens) {
+		return -1
 	}
 	return idx
 }
 
-// createNodes 在某一节点后创建新的节点链，并且返回最后一个节点的编号
-// from: 第一个不匹配的节点编号
-// segs: 需要新添加的段
-func createNodes(from int, segs []string, nodes *[]*node, nex *[][]int) int {
-	pre := (*nodes)[from]
-	for _, seg := range segs {
-		isWild := isWildcard(seg)
-		t := &node{
-			seg:      seg,
-			wildcard: isWild,
-			level:    pre.level + 1,
+// descend 从根节点开始沿着和 tokens 完全相同的节点往下走，
+// 返回最后到达的节点编号和第一个没有走完的 token 的下标，路由树为空时返回 -1
+func (tb *table) descend(tokens []string) (idx, i int) {
+	if len(tb.nodes) == 0 {
+		return -1, 0
+	}
+	for ; i < len(tokens); i++ {
+		tok, n := tokens[i], tb.nodes[idx]
+		switch {
+		case isCatchAll(tok):
+			if n.catchAll == 0 || tb.nodes[n.catchAll].prefix != tok {
+				return idx, i
+			}
+			idx = n.catchAll
+		case isWildcard(tok):
+			found := -1
+			for _, c := range n.params {
+				if tb.nodes[c].prefix == tok {
+					found = c
+				}
+			}
+			if found == -1 {
+				return idx, i
+			}
+			idx = found
+		default:
+			for len(tok) > 0 {
+				ci := tb.nodes[idx].staticChild(tok[0])
+				if ci == -1 {
+					return idx, i
+				}
+				child := tb.nodes[idx].statics[ci]
+				prefix := tb.nodes[child].prefix
+				if !strings.HasPrefix(tok, prefix) {
+					return idx, i
+				}
+				idx, tok = child, tok[len(prefix):]
+			}
+		}
+	}
+	return idx, i
+}
+
+// commonPrefix 返回 a 和 b 公共前缀的长度
+func commonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// matchNode 从编号为 idx 的节点开始匹配剩余的路径 path，返回匹配到的节点编号和 Context
+// 子节点按照 静态节点 > 带约束的参数节点 > 参数节点 > 通配节点 的优先级依次尝试，
+// 某个子节点往下匹配失败时会回溯，并撤销这个分支中提取的参数
+// 只有注册了方法的节点才算匹配成功
+// 匹配过程中不分配内存（Context 从 contextPool 中获取）
+func (tb *table) matchNode(idx int, path string, ctx *Context) (int, *Context) {
+	n := tb.nodes[idx]
+	if len(path) == 0 {
+		if n.allowMethods != 0 {
+			return idx, ctx
+		}
+		// 通配节点可以匹配空的剩余路径，例如 "/static/*filepath" 可以匹配 "/static/"
+		if n.catchAll != 0 {
+			return n.catchAll, tb.addURLParam(ctx, tb.nodes[n.catchAll].key, "")
+		}
+	}
+
+	// 静态子节点，由于请求路径去掉了末尾的 '/'，
+	// 剩余路径加上 '/' 正好等于子节点的 prefix 时，也进入子节点继续匹配
+	// 忽略大小写时可能有多个静态子节点满足条件，先尝试大小写完全一致的，再按照注册顺序尝试其他的，
+	// 避免先注册的 "/Users/:id" 抢走 "/users/me" 的请求
+	c := slashOr(path)
+	passes := 1
+	if tb.ignoreCase {
+		passes = 2
+	}
+	for pass := 0; pass < passes; pass++ {
+		for ci, b := range n.indices {
+			if b != c && (pass == 0 || lowerASCII(b) != lowerASCII(c)) {
+				continue
+			}
+			i := n.statics[ci]
+			rest, ok, exact := tb.matchStatic(path, tb.nodes[i].prefix)
+			if !ok || exact != (pass == 0) {
+				continue
+			}
+			found := 0
+			if found, ctx = tb.matchNode(i, rest, ctx); found != -1 {
+				return found, ctx
+			}
+		}
+	}
+	if len(path) == 0 {
+		return -1, ctx
+	}
+
+	// 参数子节点，参数值为到下一个 '/' 为止的内容，不能为空
+	// 参数后面还有同一段中的静态文本时（例如 ":name.:ext"），参数值在能匹配的位置中尽量短，
+	// 即从左往右依次在每个静态文本出现的位置结束，往下匹配失败时再尝试下一个位置
+	// 参数不满足约束时直接跳过这个位置
+	end := strings.IndexByte(path, '/')
+	if end == -1 {
+		end = len(path)
+	}
+	for _, i := range n.params {
+		c := tb.nodes[i]
+		pos := end
+		if c.inner() {
+			pos = 1
+		}
+		for ; pos <= end && pos > 0; pos++ {
+			if pos < end && !tb.hasStaticChild(c, path[pos]) {
+				continue
+			}
+			if c.constraint != nil && !c.constraint.MatchString(path[:pos]) {
+				continue
+			}
+			ctx = tb.addURLParam(ctx, c.key, path[:pos])
+			cnt := len(ctx.URLParams.Keys) - 1
+			found := 0
+			if found, ctx = tb.matchNode(i, path[pos:], ctx); found != -1 {
+				return found, ctx
+			}
+			ctx.URLParams.Keys = ctx.URLParams.Keys[:cnt]
+			ctx.URLParams.Values = ctx.URLParams.Values[:cnt]
+		}
+	}
+
+	// 通配节点把剩余的路径（包括 '/'）整个作为参数
+	if n.catchAll != 0 {
+		return n.catchAll, tb.addURLParam(ctx, tb.nodes[n.catchAll].key, path)
+	}
+	return -1, ctx
+}
+
+// matchStatic 判断 path 能否进入 prefix 对应的静态子节点，返回剩余的路径，
+// exact 表示开启 ignoreCase 时大小写也完全一致，没有开启时总是为 true
+func (tb *table) matchStatic(path, prefix string) (rest string, ok, exact bool) {
+	switch {
+	case tb.hasPrefix(path, prefix):
+		return path[len(prefix):], true, !tb.ignoreCase || strings.HasPrefix(path, prefix)
+	case !tb.strictSlash && len(path)+1 == len(prefix) && prefix[len(path)] == '/' && tb.hasPrefix(prefix, path):
+		return "", true, !tb.ignoreCase || strings.HasPrefix(prefix, path)
+	}
+	return "", false, false
+}
+
+// hasPrefix 判断 s 是否以 prefix 开头，开启 ignoreCase 时忽略 ASCII 字母的大小写
+func (tb *table) hasPrefix(s, prefix string) bool {
+	if !tb.ignoreCase {
+		return strings.HasPrefix(s, prefix)
+	}
+	return len(s) >= len(prefix) && equalFoldASCII(s[:len(prefix)], prefix)
+}
+
+// hasStaticChild 判断 n 是否有首字节为 c 的静态子节点，开启 ignoreCase 时忽略 ASCII 字母的大小写
+func (tb *table) hasStaticChild(n *node, c byte) bool {
+	if !tb.ignoreCase {
+		return n.staticChild(c) != -1
+	}
+	for _, b := range n.indices {
+		if lowerASCII(b) == lowerASCII(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalFoldASCII 判断 a 和 b 在忽略 ASCII 字母大小写时是否相同，非 ASCII 字符需要完全相同
+func equalFoldASCII(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if lowerASCII(a[i]) != lowerASCII(b[i]) {
+			return false
 		}
-		ti := len(*nodes)
-		*nodes = append(*nodes, t)
-		(*nex)[from] = append((*nex)[from], ti)
-		pre.wildchild = isWild
-		pre = t
-		from = ti
 	}
-	return from
+	return true
+}
+
+func lowerASCII(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
 }
 
-// isWildcard 判断是否为通配符类型的节点
+// slashOr 返回 path 的首字节，path 为空时返回 '/'
+func slashOr(path string) byte {
+	if len(path) == 0 {
+		return '/'
+	}
+	return path[0]
+}
+
+// addURLParam 把 URL 中的参数放到 Context 中，ctx 为 nil 时从 contextPool 中获取
+func (tb *table) addURLParam(ctx *Context, key, value string) *Context {
+	if ctx == nil {
+		ctx = getContext(tb.pool)
+	}
+	ctx.URLParams.Keys = append(ctx.URLParams.Keys, key)
+	ctx.URLParams.Values = append(ctx.URLParams.Values, value)
+	return ctx
+}
+
+// isWildcard 判断是否为通配符类型的节点，包括参数节点和通配节点
 func isWildcard(seg string) bool {
-	if len(seg) > 0 && seg[0] == ':' {
+	if len(seg) > 0 && (seg[0] == ':' || seg[0] == '*') {
 		return true
 	}
 	return false
 }
 
+// isCatchAll 判断是否为匹配剩余所有路径的通配节点
+func isCatchAll(seg string) bool {
+	return len(seg) > 0 && seg[0] == '*'
+}
+
 // pathToSegs 把路径以斜线 '/' 为分割符号拆成多段
 // 不允许出现 "//"、":/"、"::"、"/:xxxxx:xxxx/" 这种类型，但未尾可以有 "//"、"///" 等
+// 通配段 "*xxx" 只能作为最后一段，且必须有名字
+// 参数段可以带约束，例如 ":id<[0-9]+>"、":slug{uuid}"，约束中不能包含 '/'
+// 一段中可以有多个参数，参数之间需要有静态文本隔开，例如 ":name.:ext"、"v:major-:minor"
+// 以 '?' 结尾的段是可选的，可选段只能出现在末尾，例如 "/reports/:year/:month?"
 func pathToSegs(path string) ([]string, error) {
 	path, err := trimSlash(path)
 	if err != nil {
 		return nil, err
 	}
-	n := len(path)
-	if n > 0 && path[n-1] == ':' {
-		return nil, errors.New("Invalid path")
+	segs := strings.Split(path, "/")
+	for i := 1; i < len(segs); i++ {
+		seg := segs[i]
+		if len(seg) == 0 {
+			return nil, errors.New("empty segment")
+		}
+		if isCatchAll(seg) && i != len(segs)-1 {
+			return nil, errors.New("catch-all segment " + seg + " should be the last one")
+		}
+		if isOptional(seg) {
+			if isCatchAll(seg) {
+				return nil, errors.New("catch-all segment " + seg + " should not be optional")
+			}
+			seg = seg[:len(seg)-1]
+			if len(seg) == 0 {
+				return nil, errors.New("empty segment")
+			}
+		} else if i > 1 && isOptional(segs[i-1]) {
+			return nil, errors.New("optional segment " + segs[i-1] + " should be followed only by optional segments")
+		}
+		if _, err := splitSegment(seg); err != nil {
+			return nil, err
+		}
 	}
-	last := byte('/')
-	for i := 1; i < n; i++ {
-		a, b := path[i-1], path[i]
-		if b != ':' && b != '/' {
+	return segs, nil
+}
+
+// isOptional 判断是否为以 '?' 结尾的可选段
+func isOptional(seg string) bool {
+	return len(seg) > 0 && seg[len(seg)-1] == '?'
+}
+
+// splitSegment 把一段拆成静态文本和参数，例如 ":year-:month" => [":year", "-", ":month"]
+// 参数名由字母、数字和 '_' 组成，遇到其他字符时参数名结束，后面可以紧跟 "<...>" 或 "{...}" 约束
+// 通配段 "*xxx" 只能单独作为一段，不在段首的 '*' 是普通字符
+func splitSegment(seg string) ([]string, error) {
+	if isCatchAll(seg) {
+		if _, _, err := parseParam(seg); err != nil {
+			return nil, err
+		}
+		return []string{seg}, nil
+	}
+	parts := make([]string, 0, 1)
+	for i := 0; i < len(seg); {
+		if seg[i] != ':' {
+			j := strings.IndexByte(seg[i:], ':')
+			if j == -1 {
+				j = len(seg) - i
+			}
+			parts = append(parts, seg[i:i+j])
+			i += j
 			continue
 		}
-		if b == ':' {
-			if last == b {
-				return nil, errors.New("Invalid path")
+
+		if len(parts) > 0 && isWildcard(parts[len(parts)-1]) {
+			return nil, errors.New("params should be separated by static text in " + seg)
+		}
+		j := i + 1
+		for j < len(seg) && isParamNameByte(seg[j]) {
+			j++
+		}
+		if j < len(seg) && (seg[j] == '<' || seg[j] == '{') {
+			end := closeBracket(seg[j:])
+			if end == -1 {
+				return nil, errors.New("invalid constraint in " + seg)
 			}
-		} else {
-			if a == '/' || a == ':' {
-				return nil, errors.New("Invalid path")
+			j += end + 1
+		}
+		if _, _, err := parseParam(seg[i:j]); err != nil {
+			return nil, err
+		}
+		parts = append(parts, seg[i:j])
+		i = j
+	}
+	return parts, nil
+}
+
+// isParamNameByte 判断是否为参数名中可以使用的字符，非 ASCII 字符都可以使用
+func isParamNameByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c >= 0x80
+}
+
+// closeBracket 返回 s 开头的 '<' 或 '{' 对应的右括号的位置，找不到时返回 -1
+func closeBracket(s string) int {
+	left, right := s[0], byte('>')
+	if left == '{' {
+		right = '}'
+	}
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case left:
+			depth++
+		case right:
+			depth--
+			if depth == 0 {
+				return i
 			}
 		}
-		last = b
 	}
-	return strings.Split(path, "/"), nil
+	return -1
+}
+
+// parseParam 解析参数段和通配段，返回参数名和约束（没有约束时为 nil）
+func parseParam(seg string) (key string, constraint *regexp.Regexp, err error) {
+	key, expr, err := paramExpr(seg)
+	if err != nil || len(expr) == 0 {
+		return key, nil, err
+	}
+	constraint, err = regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return "", nil, err
+	}
+	return key, constraint, nil
+}
+
+// paramExpr 解析参数段和通配段，返回参数名和约束的正则表达式（没有约束时为空），不编译正则表达式
+func paramExpr(seg string) (key, expr string, err error) {
+	key = seg[1:]
+	if i := strings.IndexAny(key, "<{"); i != -1 {
+		key, expr = key[:i], key[i:]
+	}
+	if len(key) == 0 || strings.ContainsAny(key, ":*<>{}") {
+		return "", "", errors.New("invalid param name in " + seg)
+	}
+	if len(expr) == 0 {
+		return key, "", nil
+	}
+
+	// 通配段不支持约束
+	if isCatchAll(seg) {
+		return "", "", errors.New("catch-all segment " + seg + " should not have constraint")
+	}
+	switch last := expr[len(expr)-1]; {
+	case expr[0] == '<' && last == '>' && len(expr) > 2:
+		expr = expr[1 : len(expr)-1]
+	case expr[0] == '{' && last == '}':
+		t, ok := paramTypes[expr[1:len(expr)-1]]
+		if !ok {
+			return "", "", errors.New("unknown param type " + expr)
+		}
+		expr = t
+	default:
+		return "", "", errors.New("invalid constraint in " + seg)
+	}
+	return key, expr, nil
 }
 
 // trimSlash 去掉末尾 '/'
@@ -18,6 +18,11 @@ func Test_pathToSeg(t *testing.T) {
 		{"should pass", "/book", []string{"", "book"}},
 		{"should pass", "/book/", []string{"", "book"}},
 		{"should pass", "/book/我", []string{"", "book", "我"}},
+		{"should pass", "/static/*filepath", []string{"", "static", "*filepath"}},
+		{"should pass", "/orders/:id<[0-9]+>", []string{"", "orders", ":id<[0-9]+>"}},
+		{"should pass", "/posts/:slug{uuid}/:t<\\d{2}:\\d{2}>", []string{"", "posts", ":slug{uuid}", ":t<\\d{2}:\\d{2}>"}},
+		{"should pass", "/files/:name.:ext", []string{"", "files", ":name.:ext"}},
+		{"should pass", "/archive/:year{int}-:month<\\d{2}>.html", []string{"", "archive", ":year{int}-:month<\\d{2}>.html"}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -36,6 +41,16 @@ func Test_pathToSeg(t *testing.T) {
 		{"should not pass", "/api/:id:name", []string{}},
 		{"should not pass", "/api/:id:", []string{}},
 		{"should not pass", "/api/:/a", []string{}},
+		{"should not pass", "/static/*", []string{}},
+		{"should not pass", "/static/*filepath/a", []string{}},
+		{"should not pass", "/orders/:id<[0-9]+", []string{}},
+		{"should not pass", "/orders/:id<[0-9+>", []string{}},
+		{"should not pass", "/orders/:id{float}", []string{}},
+		{"should not pass", "/orders/:<[0-9]+>", []string{}},
+		{"should not pass", "/static/*filepath{int}", []string{}},
+		{"should not pass", "/files/:name:ext", []string{}},
+		{"should not pass", "/files/:name.:", []string{}},
+		{"should not pass", "/files/:name<[a-z]+.:ext", []string{}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {